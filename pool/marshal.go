@@ -3,9 +3,20 @@ package pool
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"strings"
 	"time"
+
+	"goa.design/pulse/pulse"
 )
 
+// compressedPayloadMarker prefixes the job payloads stored compressed in the
+// job payloads map. It is followed by the compressor name, a NUL byte and the
+// compressed payload. Payloads are only compressed and decoded by nodes
+// configured with a compressor so that the payloads of pools that do not use
+// compression are always stored and returned as is.
+const compressedPayloadMarker = "\x00pulse/compressed\x00"
+
 // marshalJob marshals a job into a byte slice.
 func marshalJob(job *Job) []byte {
 	var buf bytes.Buffer
@@ -246,3 +257,39 @@ func unmarshalAck(data []byte) *ack {
 		Error:   string(errorBytes),
 	}
 }
+
+// encodeJobPayload returns the value used to store the given job payload in
+// the job payloads map, compressed with c if not nil.
+func encodeJobPayload(payload []byte, c pulse.Compressor) (string, error) {
+	if c == nil || len(payload) == 0 {
+		return string(payload), nil
+	}
+	compressed, err := c.Compress(payload)
+	if err != nil {
+		return "", err
+	}
+	return compressedPayloadMarker + c.Name() + "\x00" + string(compressed), nil
+}
+
+// decodeJobPayload returns the job payload stored in the job payloads map by
+// encodeJobPayload using compressor c. Values that do not start with the
+// compressed payload marker were written before compression was enabled and
+// are returned as is.
+func decodeJobPayload(value string, c pulse.Compressor) ([]byte, error) {
+	if c == nil {
+		return []byte(value), nil
+	}
+	rest, ok := strings.CutPrefix(value, compressedPayloadMarker)
+	if !ok {
+		return []byte(value), nil
+	}
+	name, compressed, ok := strings.Cut(rest, "\x00")
+	if !ok {
+		return nil, fmt.Errorf("invalid compressed payload")
+	}
+	dc, ok := pulse.LookupCompressor(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown compressor %q", name)
+	}
+	return dc.Decompress([]byte(compressed))
+}
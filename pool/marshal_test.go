@@ -2,10 +2,15 @@ package pool
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
 )
 
 func TestMarshalJob(t *testing.T) {
@@ -49,3 +54,48 @@ func TestMarshalJob(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeJobPayload(t *testing.T) {
+	payload := bytes.Repeat([]byte("payload"), 100)
+	gz := pulse.GzipCompressor(gzip.DefaultCompression)
+	testCases := []struct {
+		name       string
+		payload    []byte
+		compressor pulse.Compressor
+	}{
+		{name: "uncompressed", payload: payload},
+		{name: "gzip", payload: payload, compressor: gz},
+		{name: "flate", payload: payload, compressor: pulse.FlateCompressor(flate.DefaultCompression)},
+		{name: "empty", payload: []byte{}, compressor: gz},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := encodeJobPayload(tc.payload, tc.compressor)
+			require.NoError(t, err)
+			if tc.compressor != nil && len(tc.payload) > 0 {
+				assert.Less(t, len(encoded), len(tc.payload))
+			}
+			decoded, err := decodeJobPayload(encoded, tc.compressor)
+			require.NoError(t, err)
+			assert.Equal(t, tc.payload, decoded)
+		})
+	}
+	_, err := decodeJobPayload(compressedPayloadMarker+"unknown\x00data", gz)
+	assert.Error(t, err)
+	_, err = decodeJobPayload(compressedPayloadMarker+"gzip", gz)
+	assert.Error(t, err)
+
+	// Payloads are stored and returned as is without compression
+	raw := compressedPayloadMarker + "gzip\x00data"
+	encoded, err := encodeJobPayload([]byte(raw), nil)
+	require.NoError(t, err)
+	assert.Equal(t, raw, encoded)
+	decoded, err := decodeJobPayload(encoded, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(raw), decoded)
+
+	// Payloads written before compression was enabled
+	decoded, err = decodeJobPayload("\x01legacy", gz)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x01legacy"), decoded)
+}
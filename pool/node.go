@@ -9,7 +9,6 @@ import (
 	"io"
 	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -44,12 +43,13 @@ type (
 		workerShutdownTTL  time.Duration     // Worker considered dead if not shutdown after this duration
 		ackGracePeriod     time.Duration     // Wait for return status up to this duration
 		clientOnly         bool
+		compressor         pulse.Compressor // job payloads compressor, nil if none
 		logger             pulse.Logger
 		h                  hasher
 		stop               chan struct{}  // closed when node is stopped
 		closed             chan struct{}  // closed when node is closed
 		wg                 sync.WaitGroup // allows to wait until all goroutines exit
-		rdb                *redis.Client
+		rdb                redis.UniversalClient
 
 		localWorkers       sync.Map // workers created by this node
 		workerStreams      sync.Map // worker streams indexed by ID
@@ -100,7 +100,10 @@ var ErrJobExists = errors.New("job already exists")
 // The options WithClientOnly can be used to create a node that can only be used
 // to dispatch jobs. Such a node does not route or process jobs in the
 // background.
-func AddNode(ctx context.Context, poolName string, rdb *redis.Client, opts ...NodeOption) (*Node, error) {
+//
+// rdb may be any Redis client including cluster, failover (Sentinel) and ring
+// clients.
+func AddNode(ctx context.Context, poolName string, rdb redis.UniversalClient, opts ...NodeOption) (*Node, error) {
 	o := parseOptions(opts...)
 	logger := o.logger
 	nodeID := ulid.Make().String()
@@ -134,6 +137,7 @@ func AddNode(ctx context.Context, poolName string, rdb *redis.Client, opts ...No
 
 	poolStream, err := streaming.NewStream(poolStreamName(poolName), rdb,
 		soptions.WithStreamMaxLen(o.maxQueuedJobs),
+		soptions.WithStreamCompression(o.compressor),
 		soptions.WithStreamLogger(logger))
 	if err != nil {
 		return nil, fmt.Errorf("AddNode: failed to create pool job stream %q: %w", poolStreamName(poolName), err)
@@ -225,6 +229,7 @@ func AddNode(ctx context.Context, poolName string, rdb *redis.Client, opts ...No
 		nodeStream:         nodeStream,
 		nodeReader:         nodeReader,
 		clientOnly:         o.clientOnly,
+		compressor:         o.compressor,
 		workerTTL:          o.workerTTL,
 		workerShutdownTTL:  o.workerShutdownTTL,
 		ackGracePeriod:     o.ackGracePeriod,
@@ -432,7 +437,7 @@ func (node *Node) JobKeys() []string {
 	var jobKeys []string
 	jobByNodes := node.jobsMap.Map()
 	for _, jobs := range jobByNodes {
-		jobKeys = append(jobKeys, strings.Split(jobs, ",")...)
+		jobKeys = append(jobKeys, rmap.DecodeValues(jobs)...)
 	}
 	return jobKeys
 }
@@ -441,7 +446,7 @@ func (node *Node) JobKeys() []string {
 // It returns:
 // - (payload, true) if the job exists and has a payload
 // - (nil, true) if the job exists but has an empty payload
-// - (nil, false) if the job does not exist
+// - (nil, false) if the job does not exist or its payload cannot be decoded
 func (node *Node) JobPayload(key string) ([]byte, bool) {
 	payload, ok := node.jobPayloadsMap.Get(key)
 	if !ok {
@@ -450,7 +455,12 @@ func (node *Node) JobPayload(key string) ([]byte, bool) {
 	if payload == "" {
 		return nil, true
 	}
-	return []byte(payload), true
+	decoded, err := decodeJobPayload(payload, node.compressor)
+	if err != nil {
+		node.logger.Error(fmt.Errorf("JobPayload: failed to decode payload of job %q: %w", key, err))
+		return nil, false
+	}
+	return decoded, true
 }
 
 // NotifyWorker notifies the worker that handles the job with the given key.
@@ -1101,7 +1111,9 @@ func (node *Node) deleteWorker(ctx context.Context, id string) error {
 func (node *Node) workerStream(_ context.Context, id string) (*streaming.Stream, error) {
 	val, ok := node.workerStreams.Load(id)
 	if !ok {
-		s, err := streaming.NewStream(workerStreamName(id), node.rdb, soptions.WithStreamLogger(node.logger))
+		s, err := streaming.NewStream(workerStreamName(id), node.rdb,
+			soptions.WithStreamCompression(node.compressor),
+			soptions.WithStreamLogger(node.logger))
 		if err != nil {
 			return nil, fmt.Errorf("workerStream: failed to retrieve stream for worker %q: %w", id, err)
 		}
@@ -17,6 +17,7 @@ type (
 		clientOnly           bool
 		jobSinkBlockDuration time.Duration
 		ackGracePeriod       time.Duration
+		compressor           pulse.Compressor
 		logger               pulse.Logger
 	}
 )
@@ -71,6 +72,16 @@ func WithAckGracePeriod(ttl time.Duration) NodeOption {
 	}
 }
 
+// WithCompression compresses job payloads with c, both in the pool streams
+// and in the replicated map that stores the payloads of running jobs, for
+// example pulse.GzipCompressor(gzip.BestSpeed). Payloads are decompressed
+// transparently. All the nodes of a pool should use the same compressor.
+func WithCompression(c pulse.Compressor) NodeOption {
+	return func(o *nodeOptions) {
+		o.compressor = c
+	}
+}
+
 // WithLogger sets the handler used to report temporary errors.
 func WithLogger(logger pulse.Logger) NodeOption {
 	return func(o *nodeOptions) {
@@ -94,7 +94,9 @@ func newWorker(ctx context.Context, node *Node, h JobHandler) (*Worker, error) {
 	if _, err := node.workerKeepAliveMap.SetAndWait(ctx, wid, now); err != nil {
 		return nil, fmt.Errorf("failed to update worker keep-alive: %w", err)
 	}
-	stream, err := streaming.NewStream(workerStreamName(wid), node.rdb, soptions.WithStreamLogger(node.logger))
+	stream, err := streaming.NewStream(workerStreamName(wid), node.rdb,
+		soptions.WithStreamCompression(node.compressor),
+		soptions.WithStreamLogger(node.logger))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create jobs stream for worker %q: %w", wid, err)
 	}
@@ -231,7 +233,12 @@ func (w *Worker) startJob(ctx context.Context, job *Job) error {
 		w.logger.Error(fmt.Errorf("failed to add job %q to jobs map: %w, requeueing", job.Key, err))
 		return ErrRequeue
 	}
-	if _, err := w.jobPayloadsMap.Set(ctx, job.Key, string(job.Payload)); err != nil {
+	payload, err := encodeJobPayload(job.Payload, w.node.compressor)
+	if err != nil {
+		w.logger.Error(fmt.Errorf("failed to compress job payload %q: %w, requeueing", job.Key, err))
+		return ErrRequeue
+	}
+	if _, err := w.jobPayloadsMap.Set(ctx, job.Key, payload); err != nil {
 		w.logger.Error(fmt.Errorf("failed to add job payload %q to job payloads map: %w, requeueing", job.Key, err))
 		return ErrRequeue
 	}
@@ -0,0 +1,135 @@
+package pulse
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+type (
+	// Compressor compresses and decompresses payloads. The name of the
+	// compressor is stored alongside the compressed payloads so that
+	// consumers can decompress them with the matching compressor, see
+	// RegisterCompressor.
+	Compressor interface {
+		// Name returns the unique compressor name.
+		Name() string
+		// Compress compresses data.
+		Compress(data []byte) ([]byte, error)
+		// Decompress decompresses data compressed with Compress.
+		Decompress(data []byte) ([]byte, error)
+	}
+
+	// gzipCompressor is the gzip compressor.
+	gzipCompressor struct {
+		level int
+	}
+
+	// flateCompressor is the flate compressor.
+	flateCompressor struct {
+		level int
+	}
+)
+
+var (
+	_ Compressor = (*gzipCompressor)(nil)
+	_ Compressor = (*flateCompressor)(nil)
+)
+
+var (
+	// compressorsLock protects compressors.
+	compressorsLock sync.RWMutex
+	// compressors are the registered compressors indexed by name.
+	compressors = map[string]Compressor{
+		"gzip":  GzipCompressor(gzip.DefaultCompression),
+		"flate": FlateCompressor(flate.DefaultCompression),
+	}
+)
+
+// GzipCompressor returns a compressor that uses gzip with the given
+// compression level (e.g. gzip.BestSpeed).
+func GzipCompressor(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+// FlateCompressor returns a compressor that uses DEFLATE with the given
+// compression level (e.g. flate.BestSpeed).
+func FlateCompressor(level int) Compressor {
+	return &flateCompressor{level: level}
+}
+
+// RegisterCompressor registers c so that payloads compressed with it can be
+// decompressed. The gzip and flate compressors are always registered. Pulse
+// registers the compressors given to the stream and pool options, processes
+// that only consume payloads compressed with custom compressors must register
+// them explicitly.
+func RegisterCompressor(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[c.Name()] = c
+}
+
+// LookupCompressor returns the registered compressor with the given name.
+func LookupCompressor(name string) (Compressor, bool) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// Name returns "gzip".
+func (*gzipCompressor) Name() string { return "gzip" }
+
+// Compress compresses data using gzip.
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses gzip data.
+func (*gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() // nolint: errcheck
+	return io.ReadAll(r)
+}
+
+// Name returns "flate".
+func (*flateCompressor) Name() string { return "flate" }
+
+// Compress compresses data using DEFLATE.
+func (c *flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses DEFLATE data.
+func (*flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close() // nolint: errcheck
+	return io.ReadAll(r)
+}
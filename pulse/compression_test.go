@@ -0,0 +1,65 @@
+package pulse
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reverseCompressor struct{}
+
+func (reverseCompressor) Name() string { return "reverse" }
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) { return reverse(data), nil }
+
+func (reverseCompressor) Decompress(data []byte) ([]byte, error) { return reverse(data), nil }
+
+func reverse(data []byte) []byte {
+	res := make([]byte, len(data))
+	for i, b := range data {
+		res[len(data)-1-i] = b
+	}
+	return res
+}
+
+func TestCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"temperature":42,"unit":"C"}`), 100)
+	cases := []struct {
+		name string
+		c    Compressor
+	}{
+		{"gzip", GzipCompressor(gzip.BestSpeed)},
+		{"flate", FlateCompressor(flate.BestCompression)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.name, c.c.Name())
+			compressed, err := c.c.Compress(data)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(data))
+			decompressed, err := c.c.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, data, decompressed)
+			_, err = c.c.Decompress([]byte("not compressed"))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	for _, name := range []string{"gzip", "flate"} {
+		c, ok := LookupCompressor(name)
+		assert.True(t, ok)
+		assert.Equal(t, name, c.Name())
+	}
+	_, ok := LookupCompressor("reverse")
+	assert.False(t, ok)
+	RegisterCompressor(reverseCompressor{})
+	c, ok := LookupCompressor("reverse")
+	assert.True(t, ok)
+	assert.Equal(t, reverseCompressor{}, c)
+}
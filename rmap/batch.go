@@ -0,0 +1,194 @@
+package rmap
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type (
+	// Batch is a list of operations applied atomically to a replicated map
+	// with Apply. Operations are applied in the order they were added to the
+	// batch and only if all the batch preconditions hold. A Batch is not safe
+	// for concurrent use.
+	Batch struct {
+		conds []batchArg
+		ops   []batchArg
+	}
+
+	// batchArg is a batch operation or precondition encoded as Lua script
+	// arguments.
+	batchArg struct {
+		kind string
+		key  string
+		arg  string
+	}
+)
+
+const (
+	// batchOpSet sets a key.
+	batchOpSet = "set"
+	// batchOpDelete deletes a key.
+	batchOpDelete = "del"
+	// batchOpInc increments a key.
+	batchOpInc = "inc"
+	// batchOpAppend appends values to a key.
+	batchOpAppend = "app"
+	// batchCondValue requires a key to have a given value.
+	batchCondValue = "v"
+	// batchCondRevision requires a key to have a given revision.
+	batchCondRevision = "r"
+)
+
+// NewBatch returns an empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Set adds an operation that sets the value for the given key.
+func (b *Batch) Set(key, value string) *Batch {
+	b.ops = append(b.ops, batchArg{batchOpSet, key, value})
+	return b
+}
+
+// Delete adds an operation that deletes the given key.
+func (b *Batch) Delete(key string) *Batch {
+	b.ops = append(b.ops, batchArg{batchOpDelete, key, ""})
+	return b
+}
+
+// Inc adds an operation that increments the value for the given key. The
+// batch fails without applying any operation if the value does not represent
+// an integer.
+func (b *Batch) Inc(key string, delta int) *Batch {
+	b.ops = append(b.ops, batchArg{batchOpInc, key, strconv.Itoa(delta)})
+	return b
+}
+
+// Append adds an operation that appends the given items to the value for the
+// given key, see AppendValues.
+func (b *Batch) Append(key string, items ...string) *Batch {
+	b.ops = append(b.ops, batchArg{batchOpAppend, key, EncodeValues(items)})
+	return b
+}
+
+// IfValue adds a precondition that requires the value for the given key to be
+// value when the batch is applied.
+func (b *Batch) IfValue(key, value string) *Batch {
+	b.conds = append(b.conds, batchArg{batchCondValue, key, value})
+	return b
+}
+
+// IfRevision adds a precondition that requires the revision of the given key
+// to be rev when the batch is applied. A revision of 0 requires the key not to
+// exist, keys written before revisions were tracked never satisfy it, see
+// SetIfRevision.
+func (b *Batch) IfRevision(key string, rev int64) *Batch {
+	b.conds = append(b.conds, batchArg{batchCondRevision, key, strconv.FormatInt(rev, 10)})
+	return b
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Apply applies the batch operations atomically. Either all operations are
+// applied or none are. All nodes that joined the map receive a single
+// notification for the batch and apply its operations as a unit so that no
+// node observes a partially applied batch. Apply returns false if any of the
+// batch preconditions does not hold in which case no operation is applied.
+// An error is returned if:
+// - Any key is empty
+// - Any key contains an equal sign
+// - An Inc operation targets a value that does not represent an integer
+// - There's an issue with the Redis operation
+//
+// Example:
+//
+//	applied, err := m.Apply(ctx, rmap.NewBatch().
+//		IfValue("owner", "node1").
+//		Set("owner", "node2").
+//		Delete("lease").
+//		Inc("transfers", 1))
+//
+// would transfer ownership and update the counter only if "owner" is "node1".
+func (sm *Map) Apply(ctx context.Context, b *Batch) (bool, error) {
+	if len(b.ops) == 0 {
+		return true, nil
+	}
+	keys := make([]string, 0, len(b.conds)+len(b.ops))
+	args := make([]any, 0, 2+3*(len(b.conds)+len(b.ops)))
+	args = append(args, len(b.conds))
+	for _, c := range b.conds {
+		keys = append(keys, c.key)
+		args = append(args, c.kind, c.key, c.arg)
+	}
+	for _, op := range b.ops {
+		keys = append(keys, op.key)
+		args = append(args, op.kind, op.key, op.arg)
+	}
+	if err := sm.checkWrite("apply", keys...); err != nil {
+		return false, err
+	}
+	args = append(args, sm.ID) // origin of the change
+	res, err := sm.batchScript.Run(ctx, sm.rdb, sm.scriptKeys(), args...).Result()
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("pulse map: %s failed to apply batch: %w", sm.Name, err)
+	}
+	return res.(int64) == 1, nil
+}
+
+// unpackBatch decodes the changes published for a batch along with the
+// sequence number of the update. The sequence number is the last field of the
+// payload and is 0 if the batch was published by a node that predates
+// sequence numbers.
+func unpackBatch(data []byte) ([]*Change, int64, error) {
+	origin, data, err := unpackString(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid origin: %w", err)
+	}
+	var (
+		changes []*Change
+		seq     int64
+	)
+	for len(data) > 0 {
+		var fields [4]string
+		fields[0], data, err = unpackString(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid operation %d: %w", len(changes), err)
+		}
+		if len(data) == 0 {
+			seq, err = strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid sequence number: %w", err)
+			}
+			break
+		}
+		for i := 1; i < len(fields); i++ {
+			fields[i], data, err = unpackString(data)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid operation %d: %w", len(changes), err)
+			}
+		}
+		change := &Change{Key: fields[1], Origin: origin}
+		switch fields[0] {
+		case batchOpSet:
+			change.Kind = EventChange
+			change.Value = fields[2]
+			rev, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid revision for key %s: %w", change.Key, err)
+			}
+			change.Revision = rev
+		case batchOpDelete:
+			change.Kind = EventDelete
+		default:
+			return nil, 0, fmt.Errorf("invalid operation %q", fields[0])
+		}
+		changes = append(changes, change)
+	}
+	return changes, seq, nil
+}
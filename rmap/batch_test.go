@@ -0,0 +1,127 @@
+package rmap
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	_, err = m.Set(ctx, "owner", "node1")
+	require.NoError(t, err)
+	_, err = m.Set(ctx, "lease", "x")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return m.Len() == 2 }, wf, tck)
+	c := m.SubscribeChanges()
+	n := m.Subscribe()
+
+	// Empty batch
+	applied, err := m.Apply(ctx, NewBatch())
+	assert.NoError(t, err)
+	assert.True(t, applied)
+
+	// Failed precondition
+	applied, err = m.Apply(ctx, NewBatch().IfValue("owner", "node2").Set("owner", "node3"))
+	assert.NoError(t, err)
+	assert.False(t, applied)
+	_, rev, _ := m.GetWithRevision("owner")
+	applied, err = m.Apply(ctx, NewBatch().IfRevision("owner", rev+1).Set("owner", "node3"))
+	assert.NoError(t, err)
+	assert.False(t, applied)
+
+	require.NoError(t, rdb.HSet(ctx, m.hashkey, "untracked", "x").Err())
+	applied, err = m.Apply(ctx, NewBatch().IfRevision("untracked", 0).Set("untracked", "y"))
+	assert.NoError(t, err)
+	assert.False(t, applied)
+	require.NoError(t, rdb.HDel(ctx, m.hashkey, "untracked").Err())
+
+	// Successful batch
+	applied, err = m.Apply(ctx, NewBatch().
+		IfValue("owner", "node1").
+		IfRevision("owner", rev).
+		IfRevision("missing", 0).
+		Set("owner", "node2").
+		Delete("lease").
+		Inc("transfers", 2).
+		Inc("transfers", 1).
+		Append("history", "node1", "node2"))
+	assert.NoError(t, err)
+	assert.True(t, applied)
+	assert.Eventually(t, func() bool { return m.Map()["history"] == "node1,node2" }, wf, tck)
+	assert.Equal(t, map[string]string{"owner": "node2", "transfers": "3", "history": "node1,node2"}, m.Map())
+	assert.Len(t, n, 1)
+
+	// Changes are delivered in order
+	change := readChange(t, c)
+	assert.Equal(t, EventChange, change.Kind)
+	assert.Equal(t, "owner", change.Key)
+	assert.Equal(t, "node1", change.Prev)
+	assert.Equal(t, "node2", change.Value)
+	assert.Equal(t, m.ID, change.Origin)
+	change = readChange(t, c)
+	assert.Equal(t, EventDelete, change.Kind)
+	assert.Equal(t, "lease", change.Key)
+	assert.Equal(t, "2", readChange(t, c).Value)
+	assert.Equal(t, "3", readChange(t, c).Value)
+	assert.Equal(t, "node1,node2", readChange(t, c).Value)
+
+	// Batches that fail to compute are not applied
+	_, err = m.Apply(ctx, NewBatch().Set("a", "1").Inc("owner", 1))
+	assert.Error(t, err)
+	_, ok := m.Get("a")
+	assert.False(t, ok)
+	exists, err := rdb.HExists(ctx, m.hashkey, "a").Result()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Invalid keys
+	_, err = m.Apply(ctx, NewBatch().Set("", "1"))
+	assert.Error(t, err)
+	_, err = m.Apply(ctx, NewBatch().Set("a", "1").IfValue("b=c", "1"))
+	assert.Error(t, err)
+}
+
+func TestUnpackBatch(t *testing.T) {
+	data := packStrings("origin", "set", "foo", "bar", "42", "del", "baz", "", "")
+	want := []*Change{
+		{Kind: EventChange, Key: "foo", Value: "bar", Revision: 42, Origin: "origin"},
+		{Kind: EventDelete, Key: "baz", Origin: "origin"},
+	}
+	changes, seq, err := unpackBatch(data)
+	require.NoError(t, err)
+	assert.Equal(t, want, changes)
+	assert.Zero(t, seq, "batch published without sequence number")
+
+	changes, seq, err = unpackBatch(append(data, packStrings("7")...))
+	require.NoError(t, err)
+	assert.Equal(t, want, changes)
+	assert.Equal(t, int64(7), seq)
+
+	_, _, err = unpackBatch(packStrings("origin", "set", "foo"))
+	assert.Error(t, err)
+	_, _, err = unpackBatch(packStrings("origin", "set", "foo", "bar", "notanumber"))
+	assert.Error(t, err)
+	_, _, err = unpackBatch(packStrings("origin", "unknown", "foo", "", ""))
+	assert.Error(t, err)
+	_, _, err = unpackBatch(packStrings("origin", "notanumber"))
+	assert.Error(t, err)
+}
+
+// packStrings encodes the given strings using the struct.pack "ic0" format.
+func packStrings(vals ...string) []byte {
+	var data []byte
+	for _, v := range vals {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(v)))
+		data = append(data, v...)
+	}
+	return data
+}
@@ -0,0 +1,157 @@
+package rmap
+
+import (
+	"context"
+	"sync"
+
+	"goa.design/pulse/pulse"
+)
+
+type (
+	// Change describes a single change made to the replicated map.
+	Change struct {
+		// Kind is the kind of change.
+		Kind EventKind
+		// Key is the key that changed, empty for EventReset and
+		// EventResync.
+		Key string
+		// Prev is the value of the key prior to the change as seen by the
+		// local replica, empty if the key did not exist.
+		Prev string
+		// Existed is true if the key existed prior to the change as seen by
+		// the local replica. It distinguishes keys that did not exist from
+		// keys whose previous value is the empty string.
+		Existed bool
+		// Value is the new value of the key, empty for EventDelete,
+		// EventExpire, EventReset and EventResync.
+		Value string
+		// Origin is the ID of the map instance that made the change.
+		Origin string
+		// Revision is the revision of the key after the change, 0 if the
+		// key was deleted.
+		Revision int64
+	}
+
+	// changeSub is a change subscription. Changes are queued so that the
+	// goroutine reading map updates never blocks on slow subscribers. The
+	// queue holds at most maxQueuedChanges changes.
+	changeSub struct {
+		c      chan *Change
+		match  func(key string) bool // nil matches all keys
+		notify chan struct{}
+		done   chan struct{}
+		once   sync.Once
+
+		lock  sync.Mutex
+		queue []*Change
+	}
+)
+
+// maxQueuedChanges is the maximum number of changes queued for a change
+// subscriber. Pending changes are dropped and replaced with a single
+// EventResync change when a subscriber falls further behind.
+var maxQueuedChanges = 10_000
+
+// SubscribeChanges returns a channel that receives a record for each change
+// made to the map in the order the changes were applied. Contrary to
+// Subscribe, changes are never coalesced: pending changes are queued in memory
+// until the subscriber reads them. A subscriber that falls more than 10,000
+// changes behind loses the pending changes and receives a single change of
+// kind EventResync instead, it should then re-read the map. The channel is
+// closed when the map is stopped or UnsubscribeChanges is called.
+// SubscribeChanges returns nil if the map is stopped.
+func (sm *Map) SubscribeChanges() <-chan *Change {
+	return sm.subscribeChanges(nil)
+}
+
+// UnsubscribeChanges removes the given channel from the list of change
+// subscribers and closes it. It also stops watches created with WatchKey and
+// WatchPrefix.
+func (sm *Map) UnsubscribeChanges(c <-chan *Change) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if sm.closing {
+		return
+	}
+	for i, sub := range sm.changeSubs {
+		if sub.c == c {
+			sub.close()
+			sm.changeSubs = append(sm.changeSubs[:i], sm.changeSubs[i+1:]...)
+			return
+		}
+	}
+}
+
+// subscribeChanges creates a change subscription for the keys that match.
+func (sm *Map) subscribeChanges(match func(key string) bool) <-chan *Change {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if sm.closing {
+		return nil
+	}
+	sub := &changeSub{
+		c:      make(chan *Change),
+		match:  match,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	sm.changeSubs = append(sm.changeSubs, sub)
+	pulse.Go(context.Background(), sub.deliver)
+	return sub.c
+}
+
+// matches returns true if the change must be delivered to the subscriber.
+// Changes that apply to the entire map (EventReset and EventResync) are
+// delivered to all subscribers.
+func (sub *changeSub) matches(change *Change) bool {
+	return sub.match == nil || change.Key == "" || sub.match(change.Key)
+}
+
+// push queues the change for delivery, it never blocks. If the queue is full
+// the pending changes are replaced with a single EventResync change.
+func (sub *changeSub) push(change *Change) {
+	sub.lock.Lock()
+	if len(sub.queue) >= maxQueuedChanges {
+		clear(sub.queue)
+		sub.queue = append(sub.queue[:0], &Change{Kind: EventResync})
+	}
+	sub.queue = append(sub.queue, change)
+	sub.lock.Unlock()
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the delivery of changes and closes the subscription channel. It
+// is safe to call close multiple times.
+func (sub *changeSub) close() {
+	sub.once.Do(func() { close(sub.done) })
+}
+
+// deliver sends the queued changes to the subscription channel until the
+// subscription is closed.
+func (sub *changeSub) deliver() {
+	defer close(sub.c)
+	for {
+		sub.lock.Lock()
+		if len(sub.queue) == 0 {
+			sub.lock.Unlock()
+			select {
+			case <-sub.notify:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		change := sub.queue[0]
+		sub.queue[0] = nil
+		sub.queue = sub.queue[1:]
+		sub.lock.Unlock()
+		select {
+		case sub.c <- change:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package rmap
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeChanges(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	assert.NoError(t, m.Reset(ctx))
+	c := m.SubscribeChanges()
+	require.NotNil(t, c)
+	assert.Equal(t, EventReset, readChange(t, c).Kind)
+
+	m2, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer m2.Close()
+
+	_, err = m.Set(ctx, "foo", "bar")
+	require.NoError(t, err)
+	_, err = m2.Set(ctx, "foo", "baz")
+	require.NoError(t, err)
+	_, err = m.Delete(ctx, "foo")
+	require.NoError(t, err)
+
+	first := readChange(t, c)
+	assert.Positive(t, first.Revision)
+	assert.Equal(t, &Change{Kind: EventChange, Key: "foo", Value: "bar", Origin: m.ID, Revision: first.Revision}, first)
+	assert.Equal(t, &Change{Kind: EventChange, Key: "foo", Prev: "bar", Existed: true, Value: "baz", Origin: m2.ID, Revision: first.Revision + 1}, readChange(t, c))
+	assert.Equal(t, &Change{Kind: EventDelete, Key: "foo", Prev: "baz", Existed: true, Origin: m.ID}, readChange(t, c))
+
+	// Changes are queued and not coalesced.
+	for i := 0; i < 10; i++ {
+		_, err = m.Set(ctx, "foo", fmt.Sprintf("%d", i))
+		require.NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, fmt.Sprintf("%d", i), readChange(t, c).Value)
+	}
+
+	m.UnsubscribeChanges(c)
+	assert.Eventually(t, func() bool { _, ok := <-c; return !ok }, wf, tck)
+}
+
+func TestChangeSubClose(t *testing.T) {
+	sub := &changeSub{
+		c:      make(chan *Change),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go sub.deliver()
+	sub.push(&Change{Key: "a"})
+	sub.push(&Change{Key: "b"})
+	assert.Equal(t, "a", (<-sub.c).Key)
+	assert.Equal(t, "b", (<-sub.c).Key)
+	sub.push(&Change{Key: "c"})
+	sub.close()
+	sub.close()
+	assert.Eventually(t, func() bool {
+		select {
+		case _, ok := <-sub.c:
+			return !ok
+		default:
+			return false
+		}
+	}, wf, tck)
+}
+
+func TestChangeSubOverflow(t *testing.T) {
+	defer func(prev int) { maxQueuedChanges = prev }(maxQueuedChanges)
+	maxQueuedChanges = 3
+	sub := &changeSub{
+		c:      make(chan *Change),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	defer sub.close()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		sub.push(&Change{Kind: EventChange, Key: key})
+	}
+	go sub.deliver()
+	assert.Equal(t, &Change{Kind: EventResync}, <-sub.c)
+	assert.Equal(t, "d", (<-sub.c).Key)
+	assert.Equal(t, "e", (<-sub.c).Key)
+}
+
+// readChange reads one change from c or fails the test after wf.
+func readChange(t *testing.T, c <-chan *Change) *Change {
+	t.Helper()
+	var change *Change
+	require.Eventually(t, func() bool {
+		select {
+		case change = <-c:
+			return true
+		default:
+			return false
+		}
+	}, wf, tck)
+	return change
+}
@@ -0,0 +1,279 @@
+package rmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"goa.design/pulse/pulse"
+)
+
+// Lock is a distributed lock stored in a replicated map key. Acquiring the
+// lock grants a lease that expires after the lock TTL unless it is refreshed.
+// Each acquisition returns a fencing token that is greater than the tokens
+// returned by all prior acquisitions of the lock. Fencing tokens can be passed
+// to the resources protected by the lock so that these can reject requests
+// made by holders whose lease expired. A Lock is safe for concurrent use but
+// a single lease may be held at a time per Lock value.
+type Lock struct {
+	// Key is the map key used to store the lock.
+	Key string
+
+	m   *Map
+	ttl time.Duration
+
+	lock     sync.Mutex
+	token    int64         // fencing token of current lease, 0 if not held
+	deadline time.Time     // local deadline of current lease
+	lost     chan struct{} // closed when current lease is lost or released
+	timer    *time.Timer   // fires when current lease deadline elapses
+}
+
+// ErrLockNotHeld is returned by Lock.Refresh and Lock.Release when the lease
+// is not held, either because it was never acquired, because it was released
+// or because it was lost.
+var ErrLockNotHeld = errors.New("lock not held")
+
+// NewLock returns a lock stored in the given key of m whose leases expire
+// after ttl unless refreshed. The key must not be written with other methods.
+//
+// Example:
+//
+//	l := rmap.NewLock(m, "migration", 30*time.Second)
+//	token, err := l.Acquire(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer l.Release(ctx)
+func NewLock(m *Map, key string, ttl time.Duration) *Lock {
+	return &Lock{Key: key, m: m, ttl: ttl}
+}
+
+// TryAcquire attempts to acquire the lock without waiting. It returns the
+// fencing token of the lease and true if the lock was acquired, false if it is
+// held by another owner. An error is returned if the lease is already held by
+// l, if the lock TTL is less than one millisecond or if there's an issue with
+// the Redis operation.
+func (l *Lock) TryAcquire(ctx context.Context) (int64, bool, error) {
+	// Watch the key prior to acquiring the lock so that the lease monitor
+	// observes all the changes that follow the acquisition.
+	c := l.m.WatchKey(l.Key)
+	if c == nil {
+		return 0, false, fmt.Errorf("pulse map: %s is stopped", l.m.Name)
+	}
+	token, err := l.tryAcquire(ctx, c)
+	if token == 0 {
+		l.m.UnsubscribeChanges(c)
+	}
+	return token, token > 0, err
+}
+
+// Acquire acquires the lock, waiting for the current owner to release it or
+// for its lease to expire. It returns the fencing token of the lease. An error
+// is returned if the context is canceled before the lock is acquired or under
+// the same conditions as TryAcquire.
+func (l *Lock) Acquire(ctx context.Context) (int64, error) {
+	for {
+		c := l.m.WatchKey(l.Key)
+		if c == nil {
+			return 0, fmt.Errorf("pulse map: %s is stopped", l.m.Name)
+		}
+		token, err := l.tryAcquire(ctx, c)
+		if err != nil {
+			l.m.UnsubscribeChanges(c)
+			return 0, err
+		}
+		if token > 0 {
+			return token, nil
+		}
+		err = l.waitRelease(ctx, c)
+		l.m.UnsubscribeChanges(c)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Refresh extends the current lease so that it expires after the lock TTL.
+// Refresh returns ErrLockNotHeld and closes the Lost channel if the lease was
+// lost.
+func (l *Lock) Refresh(ctx context.Context) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.token == 0 {
+		return ErrLockNotHeld
+	}
+	start := time.Now()
+	res, err := l.m.runLuaScript(ctx, "refreshLock", l.m.refreshLockScript, l.Key, l.token, l.ttl.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if res.(int64) == 0 {
+		l.m.logger.Info("lock lost", "key", l.Key, "token", l.token)
+		l.endLease()
+		return ErrLockNotHeld
+	}
+	l.deadline = start.Add(l.ttl)
+	return nil
+}
+
+// Release releases the lock and closes the Lost channel. Release returns
+// ErrLockNotHeld if the lease was not held.
+func (l *Lock) Release(ctx context.Context) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.token == 0 {
+		return ErrLockNotHeld
+	}
+	deleted, err := l.m.DeleteIfRevision(ctx, l.Key, l.token)
+	if err != nil {
+		return err
+	}
+	l.endLease()
+	if !deleted {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Token returns the fencing token of the current lease, 0 if the lease is not
+// held.
+func (l *Lock) Token() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.token
+}
+
+// Lost returns a channel that is closed when the current lease can no longer
+// be guaranteed: when it expires without being refreshed, when another owner
+// acquires the lock, when the map is reset or stopped or when the lease is
+// released. Lost returns nil if the lock was never acquired.
+func (l *Lock) Lost() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lost
+}
+
+// tryAcquire runs the acquisition script and starts monitoring the lease on
+// success. c must be a watch of the lock key created prior to calling
+// tryAcquire, it is owned by the lease monitor if tryAcquire returns a
+// non-zero token.
+func (l *Lock) tryAcquire(ctx context.Context, c <-chan *Change) (int64, error) {
+	if l.ttl < time.Millisecond {
+		return 0, fmt.Errorf("pulse map: %s invalid TTL %v for lock %s", l.m.Name, l.ttl, l.Key)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.token != 0 {
+		return 0, fmt.Errorf("pulse map: %s lock %s already acquired", l.m.Name, l.Key)
+	}
+	l.m.startExpirer() // Leases of crashed holders expire
+	start := time.Now()
+	res, err := l.m.runLuaScript(ctx, "acquireLock", l.m.acquireLockScript, l.Key, l.m.ID, l.ttl.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	token := res.(int64)
+	if token == 0 {
+		return 0, nil
+	}
+	lost := make(chan struct{})
+	l.token = token
+	l.lost = lost
+	l.deadline = start.Add(l.ttl)
+	l.timer = time.AfterFunc(l.ttl, func() { l.checkDeadline(token) })
+	pulse.Go(context.Background(), func() { l.monitor(token, lost, c) })
+	l.m.logger.Debug("lock acquired", "key", l.Key, "token", token)
+	return token, nil
+}
+
+// waitRelease waits until the lock key is deleted, expires or the map is
+// reset. It also returns after the lock TTL elapses in case the notification
+// was missed.
+func (l *Lock) waitRelease(ctx context.Context, c <-chan *Change) error {
+	timer := time.NewTimer(l.ttl)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case change, ok := <-c:
+			if !ok {
+				return fmt.Errorf("pulse map: %s is stopped", l.m.Name)
+			}
+			if change.Kind != EventChange {
+				return nil
+			}
+		}
+	}
+}
+
+// monitor watches the lock key and ends the lease identified by token when
+// the key is modified by another owner. Changes published prior to the
+// acquisition are ignored.
+func (l *Lock) monitor(token int64, lost chan struct{}, c <-chan *Change) {
+	defer l.m.UnsubscribeChanges(c)
+	seen := false
+	for {
+		select {
+		case <-lost:
+			return
+		case change, ok := <-c:
+			if !ok {
+				l.loseLease(token, "map stopped")
+				return
+			}
+			switch {
+			case change.Kind == EventResync:
+				if _, rev, _ := l.m.GetWithRevision(l.Key); rev == token {
+					seen = true
+				} else if seen {
+					l.loseLease(token, "resync")
+					return
+				}
+			case change.Revision == token:
+				seen = true
+			case seen:
+				l.loseLease(token, "key changed")
+				return
+			}
+		}
+	}
+}
+
+// checkDeadline ends the lease identified by token if its deadline elapsed.
+func (l *Lock) checkDeadline(token int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.token != token {
+		return
+	}
+	if d := time.Until(l.deadline); d > 0 {
+		l.timer.Reset(d)
+		return
+	}
+	l.m.logger.Info("lock lost", "key", l.Key, "token", token, "reason", "expired")
+	l.endLease()
+}
+
+// loseLease ends the lease identified by token.
+func (l *Lock) loseLease(token int64, reason string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.token != token {
+		return
+	}
+	l.m.logger.Info("lock lost", "key", l.Key, "token", token, "reason", reason)
+	l.endLease()
+}
+
+// endLease ends the current lease. l.lock must be held.
+func (l *Lock) endLease() {
+	l.token = 0
+	l.timer.Stop()
+	close(l.lost)
+}
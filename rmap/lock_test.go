@@ -0,0 +1,110 @@
+package rmap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	m2, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer m2.Close()
+	l1 := NewLock(m, "lock", time.Second)
+	l2 := NewLock(m2, "lock", time.Second)
+	assert.Nil(t, l1.Lost())
+
+	// Mutual exclusion
+	token1, ok, err := l1.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Positive(t, token1)
+	assert.Equal(t, token1, l1.Token())
+	_, _, err = l1.TryAcquire(ctx)
+	assert.Error(t, err, "already acquired")
+	_, ok, err = l2.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, l1.Refresh(ctx))
+
+	// Waiters are woken up on release and get a greater fencing token
+	acquired := make(chan int64)
+	go func() {
+		token, err := l2.Acquire(ctx)
+		assert.NoError(t, err)
+		acquired <- token
+	}()
+	lost := l1.Lost()
+	require.NotNil(t, lost)
+	assert.NoError(t, l1.Release(ctx))
+	assert.Eventually(t, func() bool { _, ok := <-lost; return !ok }, wf, tck)
+	var token2 int64
+	select {
+	case token2 = <-acquired:
+	case <-time.After(wf):
+		t.Fatal("lock not acquired")
+	}
+	assert.Greater(t, token2, token1)
+	assert.ErrorIs(t, l1.Release(ctx), ErrLockNotHeld)
+	assert.ErrorIs(t, l1.Refresh(ctx), ErrLockNotHeld)
+	assert.NoError(t, l2.Release(ctx))
+
+	// Context cancellation
+	_, ok, err = l1.TryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = l2.Acquire(cctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoError(t, l1.Release(ctx))
+}
+
+func TestLockExpires(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	defaultExpireCheckPeriod := expireCheckPeriod
+	expireCheckPeriod = 10 * time.Millisecond
+	defer func() { expireCheckPeriod = defaultExpireCheckPeriod }()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	l1 := NewLock(m, "lock", 100*time.Millisecond)
+	l2 := NewLock(m, "lock", 100*time.Millisecond)
+
+	// Refresh keeps the lease alive
+	token1, ok, err := l1.TryAcquire(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	lost := l1.Lost()
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, l1.Refresh(ctx))
+	}
+	select {
+	case <-lost:
+		t.Fatal("lease lost")
+	default:
+	}
+
+	// Lease is lost when not refreshed
+	token2, err := l2.Acquire(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, token2, token1)
+	assert.Eventually(t, func() bool { _, ok := <-lost; return !ok }, wf, tck)
+	assert.Equal(t, int64(0), l1.Token())
+	assert.ErrorIs(t, l1.Refresh(ctx), ErrLockNotHeld)
+
+	// Invalid TTL
+	_, _, err = NewLock(m, "other", 0).TryAcquire(ctx)
+	assert.Error(t, err)
+}
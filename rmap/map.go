@@ -13,6 +13,7 @@ import (
 
 	"goa.design/pulse/pulse"
 
+	"github.com/oklog/ulid/v2"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -22,32 +23,52 @@ type (
 	// update it.
 	Map struct {
 		Name                 string
+		ID                   string                // unique map instance ID, recorded as the origin of its changes
 		chankey              string                // Redis pubsub channel name
 		hashkey              string                // Redis hash key
+		ttlkey               string                // Redis sorted set key used to track key expirations
+		revskey              string                // Redis hash key used to store key revisions
+		revkey               string                // Redis key used to generate revisions
+		seqkey               string                // Redis key used to generate update sequence numbers
+		expirerkey           string                // Redis key used to elect the node that removes expired keys
 		msgch                <-chan *redis.Message // channel to receive map updates
 		chans                []chan EventKind      // channels to send notifications
+		changeSubs           []*changeSub          // change subscriptions
 		ichan                chan setNotification  // internal channel to send set notifications
 		done                 chan struct{}         // channel to signal shutdown
 		wait                 sync.WaitGroup        // wait for read goroutine to exit
 		logger               pulse.Logger          // logger
 		sub                  *redis.PubSub         // subscription to map updates
-		rdb                  *redis.Client
+		rdb                  redis.UniversalClient
 		setScript            *redis.Script
 		testAndSetScript     *redis.Script
 		setIfNotExistsScript *redis.Script
 		appendScript         *redis.Script
 		appendUniqueScript   *redis.Script
 		removeScript         *redis.Script
+		popScript            *redis.Script
 		incrScript           *redis.Script
 		delScript            *redis.Script
 		testAndDelScript     *redis.Script
 		testAndResetScript   *redis.Script
 		resetScript          *redis.Script
+		setWithTTLScript     *redis.Script
+		touchScript          *redis.Script
+		expireScript         *redis.Script
+		releaseExpirerScript *redis.Script
+		setIfRevisionScript  *redis.Script
+		delIfRevisionScript  *redis.Script
+		batchScript          *redis.Script
+		acquireLockScript    *redis.Script
+		refreshLockScript    *redis.Script
 
-		lock    sync.RWMutex
-		content map[string]string
-		closing bool // true if Close was called
-		closed  bool // true if Close returned
+		lock     sync.RWMutex
+		content  map[string]string
+		revs     map[string]int64 // key revisions
+		seq      int64            // sequence number of the last applied update
+		closing  bool             // true if Close was called
+		closed   bool             // true if Close returned
+		expiring bool             // true if the expiration goroutine is running
 	}
 
 	// EventKind is the type of map event.
@@ -67,8 +88,21 @@ const (
 	EventDelete
 	// EventReset is the event emitted when the map is reset.
 	EventReset
+	// EventExpire is the event emitted when a key expires.
+	EventExpire
+	// EventResync is the event emitted when the map content is reloaded
+	// from Redis after updates were missed, for example because the
+	// connection to Redis was lost.
+	EventResync
 )
 
+// expireCheckPeriod is the period at which expired keys are removed.
+var expireCheckPeriod = time.Second
+
+// maxExpiredPerRun is the maximum number of keys removed by one run of the
+// expiration script.
+const maxExpiredPerRun = 100
+
 // Join retrieves the content of the replicated map with the given name and
 // subscribes to updates. The local content is eventually consistent across all
 // nodes that join the replicated map with the same name.
@@ -78,9 +112,14 @@ const (
 // content changes (note that multiple remote changes may result in a single
 // notification). The returned Map is safe for concurrent use.
 //
+// rdb may be any Redis client including cluster, failover (Sentinel) and ring
+// clients. The Redis keys used by a map share the same hash tag so that they
+// are stored in the same cluster slot unless rdb is a *redis.Client (single
+// server or failover client), see keyPrefix.
+//
 // Clients should call Close before exiting to stop updates and release
 // resources resulting in a read-only point-in-time copy.
-func Join(ctx context.Context, name string, rdb *redis.Client, opts ...MapOption) (*Map, error) {
+func Join(ctx context.Context, name string, rdb redis.UniversalClient, opts ...MapOption) (*Map, error) {
 	if !isValidRedisKeyName(name) {
 		return nil, fmt.Errorf("pulse map: not a valid map name %q", name)
 	}
@@ -90,13 +129,20 @@ func Join(ctx context.Context, name string, rdb *redis.Client, opts ...MapOption
 	o := parseOptions(opts...)
 	sm := &Map{
 		Name:                 name,
-		chankey:              fmt.Sprintf("map:%s:updates", name),
-		hashkey:              fmt.Sprintf("map:%s:content", name),
+		ID:                   ulid.Make().String(),
+		chankey:              keyPrefix(name, rdb) + "updates",
+		hashkey:              keyPrefix(name, rdb) + "content",
+		ttlkey:               keyPrefix(name, rdb) + "ttl",
+		revskey:              keyPrefix(name, rdb) + "revs",
+		revkey:               keyPrefix(name, rdb) + "rev",
+		seqkey:               keyPrefix(name, rdb) + "seq",
+		expirerkey:           keyPrefix(name, rdb) + "expirer",
 		ichan:                make(chan setNotification, 100),
 		done:                 make(chan struct{}),
 		logger:               o.Logger.WithPrefix("map", name),
 		rdb:                  rdb,
 		content:              make(map[string]string),
+		revs:                 make(map[string]int64),
 		setScript:            luaSet,
 		testAndSetScript:     luaTestAndSet,
 		setIfNotExistsScript: luaSetIfNotExists,
@@ -104,10 +150,20 @@ func Join(ctx context.Context, name string, rdb *redis.Client, opts ...MapOption
 		appendScript:         luaAppend,
 		appendUniqueScript:   luaAppendUnique,
 		removeScript:         luaRemove,
+		popScript:            luaPop,
 		delScript:            luaDelete,
 		testAndDelScript:     luaTestAndDel,
 		testAndResetScript:   luaTestAndReset,
 		resetScript:          luaReset,
+		setWithTTLScript:     luaSetWithTTL,
+		touchScript:          luaTouch,
+		expireScript:         luaExpire,
+		releaseExpirerScript: luaReleaseExpirer,
+		setIfRevisionScript:  luaSetIfRevision,
+		delIfRevisionScript:  luaDeleteIfRevision,
+		batchScript:          luaBatch,
+		acquireLockScript:    luaAcquireLock,
+		refreshLockScript:    luaRefreshLock,
 	}
 	if err := sm.init(ctx); err != nil {
 		return nil, err
@@ -194,7 +250,19 @@ func (sm *Map) Get(key string) (string, bool) {
 	return res, ok
 }
 
-// GetValues returns the comma separated values for the given key.
+// GetWithRevision returns the value for the given key and its revision.
+// Revisions are assigned by Redis from a map-wide counter each time a key is
+// written so that a key that is deleted and re-created never reuses a previous
+// revision. Revisions are meant to be used with SetIfRevision and
+// DeleteIfRevision to implement optimistic concurrency.
+func (sm *Map) GetWithRevision(key string) (string, int64, bool) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	res, ok := sm.content[key]
+	return res, sm.revs[key], ok
+}
+
+// GetValues returns the array values for the given key, see DecodeValues.
 // This is a convenience method intended to be used in conjunction with
 // AppendValues and RemoveValues.
 func (sm *Map) GetValues(key string) ([]string, bool) {
@@ -202,7 +270,7 @@ func (sm *Map) GetValues(key string) ([]string, bool) {
 	if !ok {
 		return nil, false
 	}
-	return strings.Split(val, ","), true
+	return DecodeValues(val), true
 }
 
 // Set sets the value for the given key and returns the previous value.
@@ -215,14 +283,14 @@ func (sm *Map) GetValues(key string) ([]string, bool) {
 // Set(ctx, "color", "blue") would set the "color" key to "blue"
 // and return the previous value, if any.
 func (sm *Map) Set(ctx context.Context, key, value string) (string, error) {
-	prev, err := sm.runLuaScript(ctx, "set", sm.setScript, key, value)
-	if err != nil {
-		return "", err
-	}
-	if prev == nil {
-		return "", nil
-	}
-	return prev.(string), nil
+	prev, _, err := sm.set(ctx, key, value)
+	return prev, err
+}
+
+// set sets the value for the given key and returns the previous value and
+// whether the key existed, see Set.
+func (sm *Map) set(ctx context.Context, key, value string) (string, bool, error) {
+	return sm.runPrevScript(ctx, "set", sm.setScript, key, value)
 }
 
 // SetAndWait is a convenience method that calls Set and then waits for the
@@ -258,18 +326,28 @@ func (sm *Map) SetIfNotExists(ctx context.Context, key, value string) (bool, err
 	return v.(int64) == 1, nil
 }
 
-// TestAndSet sets the value for the given key if the current value matches the
-// given test value. The previous value is returned.
+// SetWithTTL sets the value for the given key and returns the previous value.
+// The key is removed from the map once ttl elapses unless it is refreshed with
+// Touch or set again. Expiration is enforced by Redis using the server clock so
+// that all nodes observe the same deadline, expired keys result in an
+// EventExpire notification on all nodes that joined the map. Set and
+// TestAndSet remove any expiration previously set on the key, other write
+// methods leave it unchanged.
 // An error is returned if:
 // - The key is empty
 // - The key contains an equal sign
+// - ttl is less than one millisecond
 // - There's an issue with the Redis operation
 //
 // Example:
-// TestAndSet(ctx, "color", "red", "blue") would set "color" to "blue"
-// only if its current value is "red", and return the previous value.
-func (sm *Map) TestAndSet(ctx context.Context, key, test, value string) (string, error) {
-	prev, err := sm.runLuaScript(ctx, "testAndSet", sm.testAndSetScript, key, test, value)
+// SetWithTTL(ctx, "lease", "node1", 10*time.Second) would set the "lease" key
+// to "node1" and remove it after 10 seconds unless it is touched before then.
+func (sm *Map) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) (string, error) {
+	if ttl < time.Millisecond {
+		return "", fmt.Errorf("pulse map: %s invalid TTL %v for key %s", sm.Name, ttl, key)
+	}
+	sm.startExpirer()
+	prev, err := sm.runLuaScript(ctx, "setWithTTL", sm.setWithTTLScript, key, value, ttl.Milliseconds())
 	if err != nil {
 		return "", err
 	}
@@ -279,6 +357,76 @@ func (sm *Map) TestAndSet(ctx context.Context, key, test, value string) (string,
 	return prev.(string), nil
 }
 
+// Touch resets the expiration of the given key so that it expires after ttl.
+// Touch returns false if the key does not exist. Touch can be used on keys
+// created with any write method, the key expires after ttl even if it was not
+// set with SetWithTTL.
+// An error is returned if:
+// - The key is empty
+// - The key contains an equal sign
+// - ttl is less than one millisecond
+// - There's an issue with the Redis operation
+func (sm *Map) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if ttl < time.Millisecond {
+		return false, fmt.Errorf("pulse map: %s invalid TTL %v for key %s", sm.Name, ttl, key)
+	}
+	sm.startExpirer()
+	res, err := sm.runLuaScript(ctx, "touch", sm.touchScript, key, ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	return res.(int64) == 1, nil
+}
+
+// SetIfRevision sets the value for the given key only if the current revision
+// of the key matches rev. A revision of 0 indicates that the key must not
+// exist. SetIfRevision returns the new revision and true if the value was set,
+// the current revision and false otherwise. Keys written before revisions were
+// tracked have revision 0 until they are written again with another method
+// and are never overwritten by SetIfRevision.
+// An error is returned if:
+// - The key is empty
+// - The key contains an equal sign
+// - There's an issue with the Redis operation
+//
+// Example:
+//
+//	val, rev, _ := m.GetWithRevision("jobs")
+//	newRev, ok, err := m.SetIfRevision(ctx, "jobs", val+",job", rev)
+//
+// would append to the "jobs" value only if no other node modified it since it
+// was read.
+func (sm *Map) SetIfRevision(ctx context.Context, key, value string, rev int64) (int64, bool, error) {
+	res, err := sm.runLuaScript(ctx, "setIfRevision", sm.setIfRevisionScript, key, value, rev)
+	if err != nil {
+		return 0, false, err
+	}
+	result := res.([]any)
+	return result[1].(int64), result[0].(int64) == 1, nil
+}
+
+// TestAndSet sets the value for the given key if the current value matches the
+// given test value. The previous value is returned.
+// An error is returned if:
+// - The key is empty
+// - The key contains an equal sign
+// - There's an issue with the Redis operation
+//
+// Example:
+// TestAndSet(ctx, "color", "red", "blue") would set "color" to "blue"
+// only if its current value is "red", and return the previous value.
+func (sm *Map) TestAndSet(ctx context.Context, key, test, value string) (string, error) {
+	prev, _, err := sm.testAndSet(ctx, key, test, value)
+	return prev, err
+}
+
+// testAndSet sets the value for the given key if the current value matches
+// test and returns the previous value and whether the key existed, see
+// TestAndSet.
+func (sm *Map) testAndSet(ctx context.Context, key, test, value string) (string, bool, error) {
+	return sm.runPrevScript(ctx, "testAndSet", sm.testAndSetScript, key, test, value)
+}
+
 // Inc increments the value for the given key and returns the result.
 // The value must represent an integer.
 // An error is returned if:
@@ -303,7 +451,8 @@ func (sm *Map) Inc(ctx context.Context, key string, delta int) (int, error) {
 }
 
 // AppendValues appends the given items to the value for the given key and
-// returns the result. The array of items is stored as a comma-separated list.
+// returns the result. The array of items is stored as a comma-separated list,
+// see EncodeValues.
 // An error is returned if:
 // - The key is empty
 // - The key contains an equal sign
@@ -313,7 +462,7 @@ func (sm *Map) Inc(ctx context.Context, key string, delta int) (int, error) {
 // AppendValues(ctx, "fruits", "apple", "banana") would append "apple" and "banana"
 // to the existing list of fruits and return the updated list.
 func (sm *Map) AppendValues(ctx context.Context, key string, items ...string) ([]string, error) {
-	sitems := strings.Join(items, ",")
+	sitems := EncodeValues(items)
 	res, err := sm.runLuaScript(ctx, "append", sm.appendScript, key, sitems)
 	if err != nil {
 		return nil, err
@@ -321,12 +470,12 @@ func (sm *Map) AppendValues(ctx context.Context, key string, items ...string) ([
 	if res == nil {
 		return nil, nil
 	}
-	return strings.Split(res.(string), ","), nil
+	return DecodeValues(res.(string)), nil
 }
 
 // AppendUniqueValues appends the given items to the value for the given key if
 // they are not already present and returns the result. The array of items is
-// stored as a comma-separated list.
+// stored as a comma-separated list, see EncodeValues.
 // An error is returned if:
 // - The key is empty
 // - The key contains an equal sign
@@ -336,7 +485,7 @@ func (sm *Map) AppendValues(ctx context.Context, key string, items ...string) ([
 // AppendUniqueValues(ctx, "fruits", "apple", "banana") would append only unique values
 // to the existing list of fruits and return the updated list.
 func (sm *Map) AppendUniqueValues(ctx context.Context, key string, items ...string) ([]string, error) {
-	sitems := strings.Join(items, ",")
+	sitems := EncodeValues(items)
 	res, err := sm.runLuaScript(ctx, "appendUnique", sm.appendUniqueScript, key, sitems)
 	if err != nil {
 		return nil, err
@@ -344,13 +493,14 @@ func (sm *Map) AppendUniqueValues(ctx context.Context, key string, items ...stri
 	if res == nil {
 		return nil, nil
 	}
-	return strings.Split(res.(string), ","), nil
+	return DecodeValues(res.(string)), nil
 }
 
 // RemoveValues removes the given items from the value for the given key and
 // returns the remaining values after removal. The function behaves as follows:
 //
-//  1. The value for the key is expected to be a comma-separated list of items.
+//  1. The value for the key is expected to be a list of items encoded with
+//     EncodeValues.
 //  2. It removes all occurrences of the specified items from this list.
 //  3. If the removal results in an empty list, the key is automatically deleted.
 //  4. Returns the remaining items as a slice of strings, a boolean indicating
@@ -367,7 +517,7 @@ func (sm *Map) AppendUniqueValues(ctx context.Context, key string, items ...stri
 // RemoveValues(ctx, "fruits", "apple", "cherry") would return (["banana"], true, nil)
 // and update the value in Redis to "banana"
 func (sm *Map) RemoveValues(ctx context.Context, key string, items ...string) ([]string, bool, error) {
-	sitems := strings.Join(items, ",")
+	sitems := EncodeValues(items)
 	res, err := sm.runLuaScript(ctx, "remove", sm.removeScript, key, sitems)
 	if err != nil {
 		return nil, false, err
@@ -381,7 +531,7 @@ func (sm *Map) RemoveValues(ctx context.Context, key string, items ...string) ([
 		return nil, true, nil // All items were removed, key was deleted
 	}
 	removed := result[1] != nil && result[1].(int64) == 1
-	return strings.Split(remaining, ","), removed, nil
+	return DecodeValues(remaining), removed, nil
 }
 
 // Delete deletes the value for the given key and returns the previous value.
@@ -393,14 +543,14 @@ func (sm *Map) RemoveValues(ctx context.Context, key string, items ...string) ([
 // Example:
 // Delete(ctx, "color") would delete the "color" key and return its previous value, if any.
 func (sm *Map) Delete(ctx context.Context, key string) (string, error) {
-	prev, err := sm.runLuaScript(ctx, "delete", sm.delScript, key)
-	if err != nil {
-		return "", err
-	}
-	if prev == nil {
-		return "", nil
-	}
-	return prev.(string), nil
+	prev, _, err := sm.delete(ctx, key)
+	return prev, err
+}
+
+// delete deletes the value for the given key and returns the previous value
+// and whether the key existed, see Delete.
+func (sm *Map) delete(ctx context.Context, key string) (string, bool, error) {
+	return sm.runPrevScript(ctx, "delete", sm.delScript, key)
 }
 
 // TestAndDelete tests that the value for the given key matches the test value
@@ -424,6 +574,21 @@ func (sm *Map) TestAndDelete(ctx context.Context, key, test string) (string, err
 	return prev.(string), nil
 }
 
+// DeleteIfRevision deletes the given key only if its current revision matches
+// rev. It returns true if the key was deleted. Keys with revision 0, see
+// SetIfRevision, are never deleted by DeleteIfRevision.
+// An error is returned if:
+// - The key is empty
+// - The key contains an equal sign
+// - There's an issue with the Redis operation
+func (sm *Map) DeleteIfRevision(ctx context.Context, key string, rev int64) (bool, error) {
+	res, err := sm.runLuaScript(ctx, "deleteIfRevision", sm.delIfRevisionScript, key, rev)
+	if err != nil {
+		return false, err
+	}
+	return res.([]any)[0].(int64) == 1, nil
+}
+
 // Reset clears the map content. Reset is the only method that can be called
 // after the map is closed.
 func (sm *Map) Reset(ctx context.Context) error {
@@ -491,6 +656,16 @@ func (sm *Map) init(ctx context.Context) error {
 		sm.testAndResetScript,
 		sm.testAndSetScript,
 		sm.setIfNotExistsScript,
+		sm.setWithTTLScript,
+		sm.touchScript,
+		sm.expireScript,
+		sm.releaseExpirerScript,
+		sm.setIfRevisionScript,
+		sm.delIfRevisionScript,
+		sm.batchScript,
+		sm.popScript,
+		sm.acquireLockScript,
+		sm.refreshLockScript,
 	} {
 		if err := script.Load(ctx, sm.rdb).Err(); err != nil {
 			return fmt.Errorf("pulse map: %s failed to load Lua scripts %v: %w", sm.Name, script, err)
@@ -505,20 +680,74 @@ func (sm *Map) init(ctx context.Context) error {
 	}
 	sm.msgch = sm.sub.Channel()
 
+	// Remove the keys that expired while no node was running the expiration
+	// check so that they are not loaded.
+	for {
+		n, err := sm.expireScript.Run(ctx, sm.rdb, sm.expireKeys(), sm.ID, 0, maxExpiredPerRun).Int()
+		if err != nil {
+			return fmt.Errorf("pulse map: %s failed to remove expired keys: %w", sm.Name, err)
+		}
+		if n < maxExpiredPerRun {
+			break
+		}
+	}
+
 	// read initial content
-	// Note: there's a (very) small window where we might be receiving
-	// updates for changes that are already applied by the time we call
-	// HGetAll. This is not a problem because we'll just overwrite the
-	// local copy with the same data.
-	cmd := sm.rdb.HGetAll(ctx, sm.hashkey)
-	if err := cmd.Err(); err != nil {
-		return fmt.Errorf("pulse map: %s failed to read initial content: %w", sm.Name, err)
+	// Note: updates published between the subscription and the read are
+	// received with a sequence number lower or equal to the one read with
+	// the content and are ignored.
+	content, revs, seq, err := sm.load(ctx)
+	if err != nil {
+		return err
+	}
+	sm.content, sm.revs, sm.seq = content, revs, seq
+
+	// Remove expired keys if there are keys with a TTL.
+	n, err := sm.rdb.ZCard(ctx, sm.ttlkey).Result()
+	if err != nil {
+		return fmt.Errorf("pulse map: %s failed to count keys with a TTL: %w", sm.Name, err)
+	}
+	if n > 0 {
+		sm.startExpirer()
 	}
-	sm.content = cmd.Val()
 
 	return nil
 }
 
+// load reads the map content, the key revisions and the sequence number of
+// the last published update atomically.
+func (sm *Map) load(ctx context.Context) (map[string]string, map[string]int64, int64, error) {
+	var (
+		content, revs *redis.MapStringStringCmd
+		seq           *redis.StringCmd
+	)
+	_, err := sm.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		content = pipe.HGetAll(ctx, sm.hashkey)
+		revs = pipe.HGetAll(ctx, sm.revskey)
+		seq = pipe.Get(ctx, sm.seqkey)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, nil, 0, fmt.Errorf("pulse map: %s failed to read content: %w", sm.Name, err)
+	}
+	krevs := make(map[string]int64, len(revs.Val()))
+	for key, rev := range revs.Val() {
+		r, err := strconv.ParseInt(rev, 10, 64)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("pulse map: %s invalid revision %q for key %s: %w", sm.Name, rev, key, err)
+		}
+		krevs[key] = r
+	}
+	var s int64
+	if seq.Err() == nil {
+		s, err = strconv.ParseInt(seq.Val(), 10, 64)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("pulse map: %s invalid sequence number %q: %w", sm.Name, seq.Val(), err)
+		}
+	}
+	return content.Val(), krevs, s, nil
+}
+
 // run updates the local copy of the replicated map whenever a remote update is
 // received and sends notifications when needed.
 func (sm *Map) run() {
@@ -529,54 +758,85 @@ func (sm *Map) run() {
 				// disconnected from Redis server, attempt to reconnect forever
 				sm.logger.Error(fmt.Errorf("disconnected"))
 				sm.reconnect()
+				// Updates published while disconnected are lost.
+				sm.resync()
 				continue
 			}
-			parts := strings.SplitN(msg.Payload, ":", 2)
-			if len(parts) != 2 {
+			op, data, ok := strings.Cut(msg.Payload, ":")
+			if !ok {
 				sm.logger.Error(fmt.Errorf("invalid payload"), "payload", msg.Payload)
 				continue
 			}
-			op, data := parts[0], []byte(parts[1])
+			if op == "batch" {
+				changes, seq, err := unpackBatch([]byte(data))
+				if err != nil {
+					sm.logger.Error(fmt.Errorf("invalid batch payload: %w", err))
+					continue
+				}
+				if seq == 0 || sm.inSequence(seq) {
+					sm.applyBatch(seq, changes)
+				}
+				continue
+			}
+			fields, err := unpackFields([]byte(data))
+			if err != nil && op != "reset" {
+				// Legacy reset payloads are not struct packed.
+				sm.logger.Error(fmt.Errorf("invalid %s payload", op), "payload", msg.Payload, "error", err)
+				continue
+			}
+			seq := optionalSeq(fields, seqField[op])
+			if seq != 0 && !sm.inSequence(seq) {
+				continue
+			}
 			sm.lock.Lock()
-			kind := EventChange
+			change := &Change{Kind: EventChange}
 			switch op {
 			case "reset":
+				change.Kind = EventReset
+				change.Origin = optionalField(fields, 0)
 				sm.content = make(map[string]string)
+				sm.revs = make(map[string]int64)
 				sm.logger.Debug("reset")
-				kind = EventReset
-			case "del":
-				key, _, err := unpackString(data)
-				if err != nil {
-					sm.logger.Error(fmt.Errorf("invalid del payload"), "payload", msg.Payload, "error", err)
+			case "del", "exp":
+				if len(fields) < 1 {
+					sm.logger.Error(fmt.Errorf("invalid %s payload", op), "payload", msg.Payload)
 					sm.lock.Unlock()
 					continue
 				}
+				key := fields[0]
+				change.Key = key
+				change.Prev, change.Existed = sm.content[key]
+				change.Origin = optionalField(fields, 1)
 				delete(sm.content, key)
-				sm.logger.Debug("deleted", "key", key)
-				kind = EventDelete
+				delete(sm.revs, key)
+				if op == "exp" {
+					change.Kind = EventExpire
+					sm.logger.Debug("expired", "key", key)
+				} else {
+					change.Kind = EventDelete
+					sm.logger.Debug("deleted", "key", key)
+				}
 			case "set":
-				key, rest, err := unpackString(data)
-				if err != nil {
-					sm.logger.Error(fmt.Errorf("invalid set key"), "payload", msg.Payload, "error", err)
+				if len(fields) < 2 {
+					sm.logger.Error(fmt.Errorf("invalid set payload"), "payload", msg.Payload)
 					sm.lock.Unlock()
 					continue
 				}
-				val, _, err := unpackString(rest)
-				if err != nil {
-					sm.logger.Error(fmt.Errorf("invalid set value"), "payload", msg.Payload, "error", err)
-					sm.lock.Unlock()
-					continue
+				key, val := fields[0], fields[1]
+				change.Key = key
+				change.Prev, change.Existed = sm.content[key]
+				change.Value = val
+				change.Origin = optionalField(fields, 2)
+				if rev, err := strconv.ParseInt(optionalField(fields, 3), 10, 64); err == nil {
+					change.Revision = rev
+					sm.revs[key] = rev
 				}
 				sm.content[key] = val
 				sm.ichan <- setNotification{key: key, value: val}
 				sm.logger.Debug("set", "key", key, "val", val)
 			}
-			for _, c := range sm.chans {
-				select {
-				case c <- kind:
-				default:
-				}
-			}
+			sm.advance(seq)
+			sm.notify(change.Kind, change)
 			sm.lock.Unlock()
 
 		case <-sm.done:
@@ -585,6 +845,9 @@ func (sm *Map) run() {
 			for _, c := range sm.chans {
 				close(c)
 			}
+			for _, sub := range sm.changeSubs {
+				sub.close()
+			}
 			if err := sm.sub.Unsubscribe(context.Background(), sm.chankey); err != nil {
 				sm.logger.Error(fmt.Errorf("failed to unsubscribe: %w", err))
 			}
@@ -597,28 +860,209 @@ func (sm *Map) run() {
 	}
 }
 
+// applyBatch applies the changes published for a batch as a unit and sends a
+// single notification to subscribers.
+func (sm *Map) applyBatch(seq int64, changes []*Change) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	kind := EventDelete
+	for _, change := range changes {
+		change.Prev, change.Existed = sm.content[change.Key]
+		if change.Kind == EventDelete {
+			delete(sm.content, change.Key)
+			delete(sm.revs, change.Key)
+			continue
+		}
+		kind = EventChange
+		sm.content[change.Key] = change.Value
+		sm.revs[change.Key] = change.Revision
+	}
+	sm.logger.Debug("batch", "changes", len(changes))
+	sm.advance(seq)
+	sm.notify(kind, changes...)
+}
+
+// inSequence returns true if the update with the given sequence number must
+// be applied. Updates that are already reflected in the local content are
+// ignored. If updates were missed then the map content is reloaded and
+// subscribers are notified with EventResync.
+func (sm *Map) inSequence(seq int64) bool {
+	sm.lock.RLock()
+	last := sm.seq
+	sm.lock.RUnlock()
+	if seq <= last {
+		return false
+	}
+	if seq == last+1 {
+		return true
+	}
+	sm.logger.Info("missed updates", "last", last, "received", seq)
+	sm.resync()
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return seq > sm.seq
+}
+
+// advance records seq as the sequence number of the last applied update if
+// it follows the previous one. Updates that are applied after a failed resync
+// do not advance the sequence number so that the next update triggers another
+// resync. Updates published by nodes that predate sequence numbers have a
+// zero seq and leave it unchanged. sm.lock must be held.
+func (sm *Map) advance(seq int64) {
+	if seq == sm.seq+1 {
+		sm.seq = seq
+	}
+}
+
+// resync reloads the map content from Redis and notifies subscribers with
+// EventResync if updates were missed.
+func (sm *Map) resync() {
+	content, revs, seq, err := sm.load(context.Background())
+	if err != nil {
+		sm.logger.Error(fmt.Errorf("failed to resync: %w", err))
+		return
+	}
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if seq == sm.seq {
+		return
+	}
+	sm.content, sm.revs, sm.seq = content, revs, seq
+	sm.logger.Info("resynced", "seq", seq)
+	sm.notify(EventResync, &Change{Kind: EventResync})
+}
+
+// notify sends the event kind to the subscribers and the changes to the
+// change subscribers. sm.lock must be held.
+func (sm *Map) notify(kind EventKind, changes ...*Change) {
+	for _, c := range sm.chans {
+		select {
+		case c <- kind:
+		default:
+		}
+	}
+	for _, sub := range sm.changeSubs {
+		for _, change := range changes {
+			if sub.matches(change) {
+				sub.push(change)
+			}
+		}
+	}
+}
+
 // runLuaScript runs the given Lua script, the first argument must be the key.
 // It is the caller's responsibility to make sure the map is locked.
 func (sm *Map) runLuaScript(ctx context.Context, name string, script *redis.Script, args ...any) (any, error) {
+	key := args[0].(string)
+	if err := sm.checkWrite(name, key); err != nil {
+		return nil, err
+	}
+	args = append(args, sm.ID) // origin of the change
+	res, err := script.Run(ctx, sm.rdb, sm.scriptKeys(), args...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("pulse map: %s failed to run %q for key %s: %w", sm.Name, name, key, err)
+	}
+
+	return res, nil
+}
+
+// checkWrite returns an error if the map is stopped or if any of the given
+// keys is invalid.
+func (sm *Map) checkWrite(name string, keys ...string) error {
 	sm.lock.RLock()
 	if sm.closing && name != "reset" {
 		sm.lock.RUnlock()
-		return "", fmt.Errorf("pulse map: %s is stopped", sm.Name)
+		return fmt.Errorf("pulse map: %s is stopped", sm.Name)
 	}
 	sm.lock.RUnlock()
-	key := args[0].(string)
-	if len(key) == 0 {
-		return nil, fmt.Errorf("pulse map: %s key cannot be empty in %q", sm.Name, name)
+	for _, key := range keys {
+		if len(key) == 0 {
+			return fmt.Errorf("pulse map: %s key cannot be empty in %q", sm.Name, name)
+		}
+		if strings.Contains(key, "=") {
+			return fmt.Errorf("pulse map: %s key %q cannot contain '=' in %q", sm.Name, key, name)
+		}
 	}
-	if strings.Contains(key, "=") {
-		return nil, fmt.Errorf("pulse map: %s key %q cannot contain '=' in %q", sm.Name, key, name)
+	return nil
+}
+
+// keyPrefix returns the prefix of the Redis keys used by the map with the given
+// name. Maps accessed with a *redis.Client keep the key layout of previous
+// versions (e.g. "map:name:content") so that the content of existing maps is
+// preserved and nodes running different versions share the same keys. Maps
+// accessed with other clients (e.g. cluster or ring clients) use a hash tag
+// (e.g. "map:{name}:content") so that all the keys of the map belong to the
+// same slot.
+func keyPrefix(name string, rdb redis.UniversalClient) string {
+	if _, ok := rdb.(*redis.Client); ok {
+		return "map:" + name + ":"
 	}
-	res, err := script.EvalSha(ctx, sm.rdb, []string{sm.hashkey, sm.chankey}, args...).Result()
-	if err != nil && err != redis.Nil {
-		return nil, fmt.Errorf("pulse map: %s failed to run %q for key %s: %w", sm.Name, name, key, err)
+	return "map:{" + name + "}:"
+}
+
+// runPrevScript runs a Lua script that returns the previous value of a key
+// and returns that value and whether the key existed.
+func (sm *Map) runPrevScript(ctx context.Context, name string, script *redis.Script, args ...any) (string, bool, error) {
+	prev, err := sm.runLuaScript(ctx, name, script, args...)
+	if err != nil {
+		return "", false, err
 	}
+	if prev == nil {
+		return "", false, nil
+	}
+	return prev.(string), true, nil
+}
 
-	return res, nil
+// scriptKeys returns the Redis keys used by the Lua scripts.
+func (sm *Map) scriptKeys() []string {
+	return []string{sm.hashkey, sm.chankey, sm.ttlkey, sm.revskey, sm.revkey, sm.seqkey}
+}
+
+// startExpirer starts the goroutine that removes expired keys if not already
+// running. It is started lazily by the nodes that write keys with a TTL, that
+// acquire locks or that join while keys with a TTL exist so that maps that do
+// not use TTLs do not poll Redis.
+func (sm *Map) startExpirer() {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if sm.closing || sm.expiring {
+		return
+	}
+	sm.expiring = true
+	sm.wait.Add(1)
+	pulse.Go(context.Background(), sm.expire)
+}
+
+// expire periodically removes the expired keys from the map. The nodes that
+// run the check compete for a lease so that only one of them runs it at a
+// time, the Lua script guarantees that each key is removed and notified
+// exactly once.
+func (sm *Map) expire() {
+	defer sm.wait.Done()
+	ticker := time.NewTicker(expireCheckPeriod)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	leaseDuration := 5 * expireCheckPeriod.Milliseconds()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sm.expireScript.Run(ctx, sm.rdb, sm.expireKeys(), sm.ID, leaseDuration, maxExpiredPerRun).Err(); err != nil && err != redis.Nil {
+				sm.logger.Error(fmt.Errorf("failed to remove expired keys: %w", err))
+			}
+		case <-sm.done:
+			// Let another node take over right away.
+			if err := sm.releaseExpirerScript.Run(ctx, sm.rdb, []string{sm.expirerkey}, sm.ID).Err(); err != nil {
+				sm.logger.Error(fmt.Errorf("failed to release expirer lease: %w", err))
+			}
+			return
+		}
+	}
+}
+
+// expireKeys returns the Redis keys used by the expiration Lua script.
+func (sm *Map) expireKeys() []string {
+	return append(sm.scriptKeys(), sm.expirerkey)
 }
 
 // reconnect attempts to reconnect to the Redis server forever.
@@ -667,3 +1111,47 @@ func unpackString(data []byte) (string, []byte, error) {
 	}
 	return string(data[:length]), data[length:], nil
 }
+
+// seqField is the index of the sequence number field in the notification
+// payloads of each operation. Sequence numbers are appended after the fields
+// understood by earlier versions so that nodes running different versions can
+// share a map.
+var seqField = map[string]int{"set": 4, "del": 2, "exp": 2, "reset": 1}
+
+// unpackFields reads all the length-prefixed strings of a notification
+// payload.
+func unpackFields(data []byte) ([]string, error) {
+	var fields []string
+	for len(data) > 0 {
+		var (
+			field string
+			err   error
+		)
+		field, data, err = unpackString(data)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// optionalField returns the field at index i if present and the empty string
+// otherwise. It is used to decode fields that were added to the notification
+// payloads over time.
+func optionalField(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+// optionalSeq returns the sequence number stored at index i or 0 if the
+// payload was published by a node that predates sequence numbers.
+func optionalSeq(fields []string, i int) int64 {
+	seq, err := strconv.ParseInt(optionalField(fields, i), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
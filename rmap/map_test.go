@@ -137,6 +137,22 @@ func TestMapLocal(t *testing.T) {
 	cleanup(t, m)
 }
 
+func TestKeyPrefix(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	failover := redis.NewFailoverClient(&redis.FailoverOptions{MasterName: "master", SentinelAddrs: []string{"localhost:26379"}})
+	defer failover.Close()
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}})
+	defer cluster.Close()
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"shard": "localhost:6379"}})
+	defer ring.Close()
+
+	assert.Equal(t, "map:test:", keyPrefix("test", client), "legacy layout")
+	assert.Equal(t, "map:test:", keyPrefix("test", failover), "legacy layout")
+	assert.Equal(t, "map:{test}:", keyPrefix("test", cluster), "hash tagged layout")
+	assert.Equal(t, "map:{test}:", keyPrefix("test", ring), "hash tagged layout")
+}
+
 func TestSetAndWait(t *testing.T) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     "localhost:6379",
@@ -509,6 +525,243 @@ func TestIncrement(t *testing.T) {
 	assert.Eventually(t, func() bool { return m.Map()[key] == "0" }, wf, tck)
 }
 
+func TestSetWithTTL(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	defaultExpireCheckPeriod := expireCheckPeriod
+	expireCheckPeriod = 10 * time.Millisecond
+	defer func() { expireCheckPeriod = defaultExpireCheckPeriod }()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	c := m.Subscribe()
+
+	// Invalid TTL
+	_, err = m.SetWithTTL(ctx, "foo", "bar", 0)
+	assert.Error(t, err)
+	_, err = m.Touch(ctx, "foo", 0)
+	assert.Error(t, err)
+
+	// Key expires
+	old, err := m.SetWithTTL(ctx, "foo", "bar", 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "", old)
+	assert.Eventually(t, func() bool { return m.Map()["foo"] == "bar" }, wf, tck)
+	assert.Eventually(t, func() bool { _, ok := m.Get("foo"); return !ok }, wf, tck)
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case ev := <-c:
+				if ev == EventExpire {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, wf, tck)
+
+	// Touch keeps the key alive
+	_, err = m.SetWithTTL(ctx, "foo", "bar", 200*time.Millisecond)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		ok, err := m.Touch(ctx, "foo", 200*time.Millisecond)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	v, ok := m.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", v)
+
+	// Set removes the expiration
+	_, err = m.Set(ctx, "foo", "baz")
+	assert.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	v, ok = m.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, "baz", v)
+
+	// Touch on missing key
+	ok, err = m.Touch(ctx, "missing", time.Second)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestExpireElection(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	defaultExpireCheckPeriod := expireCheckPeriod
+	expireCheckPeriod = 10 * time.Millisecond
+	defer func() { expireCheckPeriod = defaultExpireCheckPeriod }()
+	m1, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+
+	// Maps that do not use TTLs do not remove expired keys
+	time.Sleep(5 * expireCheckPeriod)
+	assert.Zero(t, rdb.Exists(ctx, m1.expirerkey).Val())
+
+	// Nodes that set keys with a TTL or join while such keys exist do
+	_, err = m1.SetWithTTL(ctx, "ttl", "value", time.Minute)
+	require.NoError(t, err)
+	m2, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m2)
+	assert.True(t, m2.expiring)
+
+	// A single node holds the expirer lease
+	var holder string
+	assert.Eventually(t, func() bool {
+		holder, err = rdb.Get(ctx, m1.expirerkey).Result()
+		return err == nil
+	}, wf, tck)
+	assert.Contains(t, []string{m1.ID, m2.ID}, holder)
+
+	// The other node takes over once the holder leaves
+	if holder == m2.ID {
+		m1, m2 = m2, m1
+	}
+	m1.Close()
+	assert.Eventually(t, func() bool { return rdb.Get(ctx, m2.expirerkey).Val() == m2.ID }, wf, tck)
+	_, err = m2.SetWithTTL(ctx, "foo", "bar", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { _, ok := m2.Get("foo"); return !ok }, wf, tck)
+}
+
+func TestExpireOnJoin(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	_, err = m.SetWithTTL(ctx, "foo", "bar", 10*time.Millisecond)
+	require.NoError(t, err)
+	m.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	// Keys that expired while no node had joined are not loaded
+	m, err = Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	_, ok := m.Get("foo")
+	assert.False(t, ok)
+	exists, err := rdb.HExists(ctx, m.hashkey, "foo").Result()
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestRevisions(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	const key = "foo"
+
+	// Revision 0 creates the key only if it does not exist
+	rev, ok, err := m.SetIfRevision(ctx, key, "bar", 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Positive(t, rev)
+	assert.Eventually(t, func() bool { _, r, _ := m.GetWithRevision(key); return r == rev }, wf, tck)
+	v, r, ok := m.GetWithRevision(key)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", v)
+	assert.Equal(t, rev, r)
+	cur, ok, err := m.SetIfRevision(ctx, key, "baz", 0)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, rev, cur)
+
+	// Stale revisions are rejected
+	rev2, ok, err := m.SetIfRevision(ctx, key, "baz", rev)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Greater(t, rev2, rev)
+	_, ok, err = m.SetIfRevision(ctx, key, "qux", rev)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Any write bumps the revision
+	_, err = m.Set(ctx, key, "baz")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { _, r, _ := m.GetWithRevision(key); return r > rev2 }, wf, tck)
+	ok, err = m.DeleteIfRevision(ctx, key, rev2)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Deletes succeed with the current revision and never reuse revisions
+	_, r, _ = m.GetWithRevision(key)
+	ok, err = m.DeleteIfRevision(ctx, key, r)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Eventually(t, func() bool { _, _, ok := m.GetWithRevision(key); return !ok }, wf, tck)
+	rev3, ok, err := m.SetIfRevision(ctx, key, "bar", 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Greater(t, rev3, r)
+
+	// Revisions are loaded when joining
+	assert.Eventually(t, func() bool { _, r, _ := m.GetWithRevision(key); return r == rev3 }, wf, tck)
+	m2, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer m2.Close()
+	_, r, ok = m2.GetWithRevision(key)
+	assert.True(t, ok)
+	assert.Equal(t, rev3, r)
+}
+
+func TestRevisionsUntrackedKey(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	const key = "legacy"
+
+	// Simulate a key written before revisions were tracked
+	require.NoError(t, rdb.HSet(ctx, m.hashkey, key, "old").Err())
+
+	rev, ok, err := m.SetIfRevision(ctx, key, "new", 0)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Zero(t, rev)
+	deleted, err := m.DeleteIfRevision(ctx, key, 0)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	val, err := rdb.HGet(ctx, m.hashkey, key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "old", val)
+}
+
+func TestResync(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	_, err = m.Set(ctx, "foo", "bar")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return m.Len() == 1 }, wf, tck)
+	c := m.SubscribeChanges()
+
+	// Simulate a missed update
+	require.NoError(t, rdb.HSet(ctx, m.hashkey, "missed", "value").Err())
+	require.NoError(t, rdb.Incr(ctx, m.seqkey).Err())
+	_, err = m.Set(ctx, "foo", "baz")
+	require.NoError(t, err)
+
+	assert.Equal(t, EventResync, readChange(t, c).Kind)
+	assert.Eventually(t, func() bool { return m.Len() == 2 }, wf, tck)
+	assert.Equal(t, map[string]string{"foo": "baz", "missed": "value"}, m.Map())
+
+	// Updates that follow the resync are applied
+	_, err = m.Set(ctx, "foo", "qux")
+	require.NoError(t, err)
+	change := readChange(t, c)
+	assert.Equal(t, EventChange, change.Kind)
+	assert.Equal(t, "qux", change.Value)
+}
+
 func TestLogs(t *testing.T) {
 	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
 	var buf Buffer
@@ -681,3 +934,34 @@ func (s *Buffer) String() string {
 	defer s.mutex.Unlock()
 	return s.buffer.String()
 }
+
+func TestUnpackFields(t *testing.T) {
+	cases := []struct {
+		name   string
+		op     string
+		data   []byte
+		fields []string
+		seq    int64
+	}{
+		{"legacy set", "set", packStrings("foo", "bar"), []string{"foo", "bar"}, 0},
+		{"set with revision", "set", packStrings("foo", "bar", "node", "3"), []string{"foo", "bar", "node", "3"}, 0},
+		{"set", "set", packStrings("foo", "bar", "node", "3", "12"), []string{"foo", "bar", "node", "3", "12"}, 12},
+		{"legacy del", "del", packStrings("foo"), []string{"foo"}, 0},
+		{"del with origin", "del", packStrings("foo", "node"), []string{"foo", "node"}, 0},
+		{"del", "del", packStrings("foo", "node", "12"), []string{"foo", "node", "12"}, 12},
+		{"exp", "exp", packStrings("foo", "node", "12"), []string{"foo", "node", "12"}, 12},
+		{"reset with origin", "reset", packStrings("node"), []string{"node"}, 0},
+		{"reset", "reset", packStrings("node", "12"), []string{"node", "12"}, 12},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fields, err := unpackFields(c.data)
+			require.NoError(t, err)
+			assert.Equal(t, c.fields, fields)
+			assert.Equal(t, c.seq, optionalSeq(fields, seqField[c.op]))
+		})
+	}
+	_, err := unpackFields([]byte("*"))
+	assert.Error(t, err, "legacy reset payload")
+	assert.Equal(t, "", optionalField(nil, 0))
+}
@@ -2,152 +2,459 @@ package rmap
 
 import "github.com/redis/go-redis/v9"
 
+// luaValues defines the Lua functions used to decode and encode array values,
+// see EncodeValues and DecodeValues.
+const luaValues = `
+	   local function decodeValues(s)
+	      local items = {}
+	      if not s or s == "" then
+	         return items
+	      end
+	      local cur, i = "", 1
+	      while true do
+	         local j = string.find(s, "[\\,]", i)
+	         if not j then
+	            cur = cur .. string.sub(s, i)
+	            break
+	         end
+	         cur = cur .. string.sub(s, i, j - 1)
+	         if string.sub(s, j, j) == "," then
+	            table.insert(items, cur)
+	            cur = ""
+	            i = j + 1
+	         else
+	            cur = cur .. string.sub(s, j + 1, j + 1)
+	            i = j + 2
+	         end
+	      end
+	      table.insert(items, cur)
+	      return items
+	   end
+
+	   local function encodeValues(items)
+	      local encoded = {}
+	      for i, item in ipairs(items) do
+	         encoded[i] = string.gsub(item, "[\\,]", "\\%0")
+	      end
+	      return table.concat(encoded, ",")
+	   end
+`
+
 var (
 	// luaAppend is the Lua script used to append an item to an array key and
 	// return its new value.
 	luaAppend = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
 
 	   -- If the value exists, append the new value, otherwise assign ARGV[2] directly
-	   v = (v and v .. "," .. ARGV[2]) or ARGV[2]
+	   -- Items are encoded so that the encoded lists can simply be concatenated.
+	   v = (v and v ~= "" and v .. "," .. ARGV[2]) or ARGV[2]
 
 	   -- Set the updated value in the hash and publish the change
 	   redis.call("HSET", KEYS[1], ARGV[1], v)
-	   local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v)
-	   redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+	   local rev = tostring(redis.call("INCR", KEYS[5]))
+	   redis.call("HSET", KEYS[4], ARGV[1], rev)
+	   local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v, string.len(origin), origin, string.len(rev), rev)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 
 	   return v
 	`)
 
 	// luaAppendUnique is the Lua script used to append an item to a set and return
 	// the result.
-	luaAppendUnique = redis.NewScript(`
+	luaAppendUnique = redis.NewScript(luaValues + `
+	  local origin = ARGV[#ARGV]
 	  local v = redis.call("HGET", KEYS[1], ARGV[1])
-	  local newValues = {}
-	  local changed = false
+	  local values = decodeValues(v)
+	  local changed = not v
 
-	  -- Split ARGV[2] into a table of new values
-	  for value in string.gmatch(ARGV[2], "[^,]+") do
-	    table.insert(newValues, value)
+	  -- Append new values that are not already present
+	  local existing = {}
+	  for _, value in ipairs(values) do
+	    existing[value] = true
 	  end
-
-	  -- If the value exists, process it, else set it directly
-	  if v then
-	    local existingValues = {}
-	    -- Split existing values into a table
-	    for value in string.gmatch(v, "[^,]+") do
-	      existingValues[value] = true
+	  for _, value in ipairs(decodeValues(ARGV[2])) do
+	    if not existing[value] then
+	      existing[value] = true
+	      table.insert(values, value)
+	      changed = true
 	    end
-
-	    -- Append unique new values to v
-	    for _, newValue in ipairs(newValues) do
-	      if not existingValues[newValue] then
-	        v = (v == "") and newValue or v .. "," .. newValue
-	        changed = true
-	      end
-	    end
-	  else
-	    v = table.concat(newValues, ",")
-	    changed = true
 	  end
+	  v = encodeValues(values)
 
 	  -- If changes were made, update the hash and publish the event
 	  if changed then
 	    redis.call("HSET", KEYS[1], ARGV[1], v)
-	    local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v)
-	    redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+	    local rev = tostring(redis.call("INCR", KEYS[5]))
+	    redis.call("HSET", KEYS[4], ARGV[1], rev)
+	    local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v, string.len(origin), origin, string.len(rev), rev)
+	    local seq = tostring(redis.call("INCR", KEYS[6]))
+	    redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	  end
 
 	  return v
 	`)
 
+	// luaAcquireLock is the Lua script used to create a key with a TTL (in
+	// milliseconds) if it does not exist or has expired. It returns the new
+	// revision of the key or 0 if the key exists.
+	luaAcquireLock = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
+	   local t = redis.call("TIME")
+	   local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	   if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	      local deadline = redis.call("ZSCORE", KEYS[3], ARGV[1])
+	      if not deadline or tonumber(deadline) > now then
+	         return 0
+	      end
+	   end
+	   redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	   redis.call("ZADD", KEYS[3], now + tonumber(ARGV[3]), ARGV[1])
+	   local rev = tostring(redis.call("INCR", KEYS[5]))
+	   redis.call("HSET", KEYS[4], ARGV[1], rev)
+	   local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2], string.len(origin), origin, string.len(rev), rev)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   return tonumber(rev)
+	`)
+
 	// luaDelete is the Lua script used to delete a key and return its previous
 	// value.
 	luaDelete = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
 	   redis.call("HDEL", KEYS[1], ARGV[1])
-	   local msg = struct.pack("ic0", string.len(ARGV[1]), ARGV[1])
-	   redis.call("PUBLISH", KEYS[2], "del:" .. msg)
+	   redis.call("ZREM", KEYS[3], ARGV[1])
+	   redis.call("HDEL", KEYS[4], ARGV[1])
+	   local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(origin), origin)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "del:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	   return v
 	`)
 
+	// luaBatch is the Lua script used to apply a batch of operations. ARGV[1]
+	// is the number of preconditions followed by the preconditions and the
+	// operations, each encoded as a (kind, key, argument) triplet. The script
+	// first checks the preconditions and computes all the new values so that
+	// errors are raised before any write, it then applies the changes and
+	// publishes a single notification.
+	luaBatch = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
+	   local nc = tonumber(ARGV[1])
+
+	   -- Check preconditions
+	   for i = 2, 1 + nc * 3, 3 do
+	      local kind, key, arg = ARGV[i], ARGV[i + 1], ARGV[i + 2]
+	      if kind == "v" then
+	         if redis.call("HGET", KEYS[1], key) ~= arg then
+	            return 0
+	         end
+	      else
+	         local rev = tonumber(redis.call("HGET", KEYS[4], key) or "0")
+	         if rev ~= tonumber(arg) then
+	            return 0
+	         end
+	         -- Keys written before revisions were tracked have no revision but exist.
+	         if rev == 0 and redis.call("HEXISTS", KEYS[1], key) == 1 then
+	            return 0
+	         end
+	      end
+	   end
+
+	   -- Compute new values
+	   local state = {}
+	   local function get(key)
+	      if state[key] ~= nil then
+	         return state[key]
+	      end
+	      return redis.call("HGET", KEYS[1], key)
+	   end
+	   local changes = {}
+	   for i = 2 + nc * 3, #ARGV - 1, 3 do
+	      local kind, key, arg = ARGV[i], ARGV[i + 1], ARGV[i + 2]
+	      local v = false
+	      if kind == "set" then
+	         v = arg
+	      elseif kind == "inc" then
+	         local n = tonumber(get(key) or "0")
+	         if not n or math.floor(n) ~= n then
+	            return redis.error_reply("ERR value of key " .. key .. " is not an integer")
+	         end
+	         v = string.format("%d", n + tonumber(arg))
+	      elseif kind == "app" then
+	         local curr = get(key)
+	         v = (curr and curr ~= "" and curr .. "," .. arg) or arg
+	      end
+	      state[key] = v
+	      table.insert(changes, {kind, key, v})
+	   end
+
+	   -- Apply changes
+	   local msg = struct.pack("ic0", string.len(origin), origin)
+	   for _, change in ipairs(changes) do
+	      local kind, key, v = change[1], change[2], change[3]
+	      if v then
+	         redis.call("HSET", KEYS[1], key, v)
+	         if kind == "set" then
+	            redis.call("ZREM", KEYS[3], key)
+	         end
+	         local rev = tostring(redis.call("INCR", KEYS[5]))
+	         redis.call("HSET", KEYS[4], key, rev)
+	         msg = msg .. struct.pack("ic0ic0ic0ic0", 3, "set", string.len(key), key, string.len(v), v, string.len(rev), rev)
+	      else
+	         redis.call("HDEL", KEYS[1], key)
+	         redis.call("ZREM", KEYS[3], key)
+	         redis.call("HDEL", KEYS[4], key)
+	         msg = msg .. struct.pack("ic0ic0ic0ic0", 3, "del", string.len(key), key, 0, "", 0, "")
+	      end
+	   end
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "batch:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   return 1
+	`)
+
+	// luaDeleteIfRevision is the Lua script used to delete a key if its revision
+	// matches the given revision. It returns a flag indicating whether the key
+	// was deleted and the current revision.
+	luaDeleteIfRevision = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
+	   local rev = tonumber(redis.call("HGET", KEYS[4], ARGV[1]) or "0")
+	   -- Revision 0 never matches: the key either does not exist or was
+	   -- written before revisions were tracked.
+	   if rev ~= tonumber(ARGV[2]) or rev == 0 then
+	      return {0, rev}
+	   end
+	   redis.call("HDEL", KEYS[1], ARGV[1])
+	   redis.call("ZREM", KEYS[3], ARGV[1])
+	   redis.call("HDEL", KEYS[4], ARGV[1])
+	   local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(origin), origin)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "del:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   return {1, 0}
+	`)
+
+	// luaExpire is the Lua script used to delete the keys whose TTL has elapsed.
+	// The current time is read from the Redis server so that expirations do not
+	// depend on the clocks of the nodes that joined the map. At most ARGV[3]
+	// keys are deleted per run. If ARGV[2] is not
+	// 0 then the keys are only deleted if the caller holds the expirer lease
+	// stored in KEYS[7] (acquiring it for ARGV[2] milliseconds if needed) and
+	// the script returns -1 otherwise.
+	luaExpire = redis.NewScript(`
+	   local origin = ARGV[1]
+	   if ARGV[2] ~= "0" then
+	      local owner = redis.call("GET", KEYS[7])
+	      if owner and owner ~= origin then
+	         return -1
+	      end
+	      redis.call("SET", KEYS[7], origin, "PX", ARGV[2])
+	   end
+	   local t = redis.call("TIME")
+	   local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	   local keys = redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", now, "LIMIT", 0, ARGV[3])
+	   for _, key in ipairs(keys) do
+	      redis.call("HDEL", KEYS[1], key)
+	      redis.call("ZREM", KEYS[3], key)
+	      redis.call("HDEL", KEYS[4], key)
+	      local msg = struct.pack("ic0ic0", string.len(key), key, string.len(origin), origin)
+	      local seq = tostring(redis.call("INCR", KEYS[6]))
+	      redis.call("PUBLISH", KEYS[2], "exp:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   end
+	   return #keys
+	`)
+
+	// luaReleaseExpirer is the Lua script used to release the expirer lease if
+	// held by the caller.
+	luaReleaseExpirer = redis.NewScript(`
+	   if redis.call("GET", KEYS[1]) == ARGV[1] then
+	      redis.call("DEL", KEYS[1])
+	   end
+	   return 0
+	`)
+
 	// luaIncr is the Lua script used to increment a key and return the new value.
 	luaIncr = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
 	   redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2])
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
-	   local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v)
-	   redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+	   local rev = tostring(redis.call("INCR", KEYS[5]))
+	   redis.call("HSET", KEYS[4], ARGV[1], rev)
+	   local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v, string.len(origin), origin, string.len(rev), rev)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	   return v
 	`)
 
 	// luaRemove is the Lua script used to remove items from an array value and
 	// return the result along with a flag indicating if any value was removed.
-	luaRemove = redis.NewScript(`
+	luaRemove = redis.NewScript(luaValues + `
+	   local origin = ARGV[#ARGV]
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
 	   local removed = false
 
 	   if v then
-	      -- Create a set of current values
-	      local curr = {}
-	      for s in string.gmatch(v, "[^,]+") do
-	         curr[s] = true
+	      -- Create a set of values to remove
+	      local rem = {}
+	      for _, s in ipairs(decodeValues(ARGV[2])) do
+	         rem[s] = true
 	      end
 
-	      -- Remove specified values
-	      for s in string.gmatch(ARGV[2], "[^,]+") do
-	         if curr[s] then
-	            curr[s] = nil
+	      -- Collect the remaining values preserving their order
+	      local newValues = {}
+	      for _, s in ipairs(decodeValues(v)) do
+	         if rem[s] then
 	            removed = true
+	         else
+	            table.insert(newValues, s)
 	         end
 	      end
 
-	      -- Collect the remaining values
-	      local newValues = {}
-	      for key, _ in pairs(curr) do
-	         table.insert(newValues, key)
-	      end
-
 	      -- Update the hash or delete the key if empty
 	      if #newValues == 0 then
 	         redis.call("HDEL", KEYS[1], ARGV[1])
-	         local msg = struct.pack("ic0", string.len(ARGV[1]), ARGV[1])
-	         redis.call("PUBLISH", KEYS[2], "del:" .. msg)
+	         redis.call("ZREM", KEYS[3], ARGV[1])
+	         redis.call("HDEL", KEYS[4], ARGV[1])
+	         local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(origin), origin)
+	         local seq = tostring(redis.call("INCR", KEYS[6]))
+	         redis.call("PUBLISH", KEYS[2], "del:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	         v = ""
-	      else
-	         v = table.concat(newValues, ",")
+	      elseif removed then
+	         v = encodeValues(newValues)
 	         redis.call("HSET", KEYS[1], ARGV[1], v)
-	         local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v)
-	         redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+	         local rev = tostring(redis.call("INCR", KEYS[5]))
+	         redis.call("HSET", KEYS[4], ARGV[1], rev)
+	         local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v, string.len(origin), origin, string.len(rev), rev)
+	         local seq = tostring(redis.call("INCR", KEYS[6]))
+	         redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	      end
 	   end
 
 	   return {v, removed}
 	`)
 
+	// luaPop is the Lua script used to remove the first item from an array
+	// value and return it. The key is deleted if the array becomes empty.
+	luaPop = redis.NewScript(luaValues + `
+	   local origin = ARGV[#ARGV]
+	   local v = redis.call("HGET", KEYS[1], ARGV[1])
+	   if not v then
+	      return false
+	   end
+	   local values = decodeValues(v)
+	   local item = table.remove(values, 1)
+	   if #values == 0 then
+	      redis.call("HDEL", KEYS[1], ARGV[1])
+	      redis.call("ZREM", KEYS[3], ARGV[1])
+	      redis.call("HDEL", KEYS[4], ARGV[1])
+	      local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(origin), origin)
+	      local seq = tostring(redis.call("INCR", KEYS[6]))
+	      redis.call("PUBLISH", KEYS[2], "del:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   else
+	      v = encodeValues(values)
+	      redis.call("HSET", KEYS[1], ARGV[1], v)
+	      local rev = tostring(redis.call("INCR", KEYS[5]))
+	      redis.call("HSET", KEYS[4], ARGV[1], rev)
+	      local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(v), v, string.len(origin), origin, string.len(rev), rev)
+	      local seq = tostring(redis.call("INCR", KEYS[6]))
+	      redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   end
+	   return item or ""
+	`)
+
+	// luaRefreshLock is the Lua script used to reset the TTL (in milliseconds)
+	// of a key if its revision matches ARGV[2] and it has not expired.
+	luaRefreshLock = redis.NewScript(`
+	   if tonumber(redis.call("HGET", KEYS[4], ARGV[1]) or "0") ~= tonumber(ARGV[2]) then
+	      return 0
+	   end
+	   local t = redis.call("TIME")
+	   local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	   local deadline = redis.call("ZSCORE", KEYS[3], ARGV[1])
+	   if not deadline or tonumber(deadline) <= now then
+	      return 0
+	   end
+	   redis.call("ZADD", KEYS[3], now + tonumber(ARGV[3]), ARGV[1])
+	   return 1
+	`)
+
 	// luaReset is the Lua script used to reset the map.
 	luaReset = redis.NewScript(`
-	   redis.call("DEL", KEYS[1])
-	   redis.call("PUBLISH", KEYS[2], "reset:*")
+	   local origin = ARGV[#ARGV]
+	   redis.call("DEL", KEYS[1], KEYS[3], KEYS[4])
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "reset:" .. struct.pack("ic0ic0", string.len(origin), origin, string.len(seq), seq))
 	`)
 
 	// luaSet is the Lua script used to set a key and return its previous value.  We
 	// use Lua scripts to publish notifications "at the same time" and preserve the
 	// order of operations (scripts are run atomically within Redis).
 	luaSet = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
 	   redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
-	   local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2])
-	   redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+	   redis.call("ZREM", KEYS[3], ARGV[1])
+	   local rev = tostring(redis.call("INCR", KEYS[5]))
+	   redis.call("HSET", KEYS[4], ARGV[1], rev)
+	   local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2], string.len(origin), origin, string.len(rev), rev)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   return v
+	`)
+
+	// luaSetIfRevision is the Lua script used to set a key if its revision
+	// matches the given revision, revision 0 means that the key must not exist.
+	// It returns a flag indicating whether the key was set and the current
+	// revision.
+	luaSetIfRevision = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
+	   local curr = tonumber(redis.call("HGET", KEYS[4], ARGV[1]) or "0")
+	   if curr ~= tonumber(ARGV[3]) then
+	      return {0, curr}
+	   end
+	   -- Keys written before revisions were tracked have no revision but exist.
+	   if curr == 0 and redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	      return {0, 0}
+	   end
+	   redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	   redis.call("ZREM", KEYS[3], ARGV[1])
+	   local rev = tostring(redis.call("INCR", KEYS[5]))
+	   redis.call("HSET", KEYS[4], ARGV[1], rev)
+	   local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2], string.len(origin), origin, string.len(rev), rev)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
+	   return {1, tonumber(rev)}
+	`)
+
+	// luaSetWithTTL is the Lua script used to set a key with a TTL (in
+	// milliseconds) and return its previous value.
+	luaSetWithTTL = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
+	   local v = redis.call("HGET", KEYS[1], ARGV[1])
+	   local t = redis.call("TIME")
+	   local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	   redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	   redis.call("ZADD", KEYS[3], now + tonumber(ARGV[3]), ARGV[1])
+	   local rev = tostring(redis.call("INCR", KEYS[5]))
+	   redis.call("HSET", KEYS[4], ARGV[1], rev)
+	   local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2], string.len(origin), origin, string.len(rev), rev)
+	   local seq = tostring(redis.call("INCR", KEYS[6]))
+	   redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	   return v
 	`)
 
 	// luaTestAndDel is the Lua script used to delete a key if it has a specific value.
 	luaTestAndDel = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
 	   if v == ARGV[2] then
 	      redis.call("HDEL", KEYS[1], ARGV[1])
-	      local msg = struct.pack("ic0", string.len(ARGV[1]), ARGV[1])
-	      redis.call("PUBLISH", KEYS[2], "del:" .. msg)
+	      redis.call("ZREM", KEYS[3], ARGV[1])
+	      redis.call("HDEL", KEYS[4], ARGV[1])
+	      local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(origin), origin)
+	      local seq = tostring(redis.call("INCR", KEYS[6]))
+	      redis.call("PUBLISH", KEYS[2], "del:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	   end
 	   return v
 	`)
@@ -155,8 +462,9 @@ var (
 	// luaTestAndReset is the Lua script used to reset the map if all the given keys
 	// have the given values.
 	luaTestAndReset = redis.NewScript(`
+	  local origin = ARGV[#ARGV]
 	  local hash = KEYS[1]
-	  local n = (#ARGV - 1) / 2
+	  local n = (#ARGV - 2) / 2
 	  
 	  for i = 2, n + 1 do
 	      if redis.call("HGET", hash, ARGV[i]) ~= ARGV[i + n] then
@@ -164,31 +472,54 @@ var (
 	      end
 	  end
 	  
-	  redis.call("DEL", hash)
-	  redis.call("PUBLISH", KEYS[2], "reset:*")
+	  redis.call("DEL", hash, KEYS[3], KEYS[4])
+	  local seq = tostring(redis.call("INCR", KEYS[6]))
+	  redis.call("PUBLISH", KEYS[2], "reset:" .. struct.pack("ic0ic0", string.len(origin), origin, string.len(seq), seq))
 	  return 1
 	`)
 
 	// luaTestAndSet is the Lua script used to set a key if it has a specific value.
 	luaTestAndSet = redis.NewScript(`
+	   local origin = ARGV[#ARGV]
 	   local v = redis.call("HGET", KEYS[1], ARGV[1])
 	   if v == ARGV[2] then
 	      redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
-	      local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[3]), ARGV[3])
-	      redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+	      redis.call("ZREM", KEYS[3], ARGV[1])
+	      local rev = tostring(redis.call("INCR", KEYS[5]))
+	      redis.call("HSET", KEYS[4], ARGV[1], rev)
+	      local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[3]), ARGV[3], string.len(origin), origin, string.len(rev), rev)
+	      local seq = tostring(redis.call("INCR", KEYS[6]))
+	      redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
 	   end
 	   return v
 	`)
 
 	// luaSetIfNotExists is the Lua script used to set a key if it does not exist.
 	luaSetIfNotExists = redis.NewScript(`
+        local origin = ARGV[#ARGV]
         local v = redis.call("HGET", KEYS[1], ARGV[1])
         if not v then
             redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
-            local msg = struct.pack("ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2])
-            redis.call("PUBLISH", KEYS[2], "set:" .. msg)
+            redis.call("ZREM", KEYS[3], ARGV[1])
+            local rev = tostring(redis.call("INCR", KEYS[5]))
+            redis.call("HSET", KEYS[4], ARGV[1], rev)
+            local msg = struct.pack("ic0ic0ic0ic0", string.len(ARGV[1]), ARGV[1], string.len(ARGV[2]), ARGV[2], string.len(origin), origin, string.len(rev), rev)
+            local seq = tostring(redis.call("INCR", KEYS[6]))
+            redis.call("PUBLISH", KEYS[2], "set:" .. msg .. struct.pack("ic0", string.len(seq), seq))
             return 1  -- Successfully set the value
         end
         return 0    -- Value already existed
     `)
+
+	// luaTouch is the Lua script used to reset the TTL (in milliseconds) of an
+	// existing key.
+	luaTouch = redis.NewScript(`
+	   if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	      return 0
+	   end
+	   local t = redis.call("TIME")
+	   local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	   redis.call("ZADD", KEYS[3], now + tonumber(ARGV[2]), ARGV[1])
+	   return 1
+	`)
 )
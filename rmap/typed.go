@@ -0,0 +1,319 @@
+package rmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"goa.design/pulse/pulse"
+)
+
+type (
+	// TypedMap is a replicated map whose values are of type V. Values are
+	// encoded with a Codec before being stored in the underlying Map.
+	TypedMap[V any] struct {
+		m     *Map
+		codec Codec[V]
+
+		lock sync.Mutex
+		subs map[<-chan *TypedChange[V]]*typedChangeSub[V]
+	}
+
+	// TypedChange describes a single change made to a typed map, see Change.
+	TypedChange[V any] struct {
+		// Kind is the kind of change.
+		Kind EventKind
+		// Key is the key that changed, empty for EventReset and
+		// EventResync.
+		Key string
+		// Prev is the decoded value of the key prior to the change as seen
+		// by the local replica, the zero value if the key did not exist.
+		Prev V
+		// Existed is true if the key existed prior to the change as seen by
+		// the local replica.
+		Existed bool
+		// Value is the decoded new value of the key, the zero value for
+		// EventDelete, EventExpire, EventReset and EventResync.
+		Value V
+		// Origin is the ID of the map instance that made the change.
+		Origin string
+		// Revision is the revision of the key after the change, 0 if the
+		// key was deleted.
+		Revision int64
+		// Err is set if the previous or new value could not be decoded.
+		Err error
+	}
+
+	// typedChangeSub is a typed change subscription that decodes the
+	// changes received on the underlying change subscription.
+	typedChangeSub[V any] struct {
+		raw  <-chan *Change
+		c    chan *TypedChange[V]
+		done chan struct{}
+	}
+
+	// Codec encodes and decodes the values of a TypedMap.
+	Codec[V any] interface {
+		// Encode encodes the given value.
+		Encode(V) (string, error)
+		// Decode decodes the given encoded value.
+		Decode(string) (V, error)
+	}
+
+	// jsonCodec is a Codec that uses JSON to encode values.
+	jsonCodec[V any] struct{}
+
+	// gobCodec is a Codec that uses gob to encode values.
+	gobCodec[V any] struct{}
+
+	// bytesCodec is a Codec that stores byte slices as is.
+	bytesCodec struct{}
+)
+
+// NewTypedMap returns a typed map that stores values in m using codec.
+// Multiple typed maps must not share the same underlying map unless they use
+// the same codec.
+//
+// Example:
+//
+//	m, err := rmap.Join(ctx, "config", rdb)
+//	if err != nil {
+//		return err
+//	}
+//	tm := rmap.NewTypedMap(m, rmap.JSONCodec[Config]())
+func NewTypedMap[V any](m *Map, codec Codec[V]) *TypedMap[V] {
+	return &TypedMap[V]{m: m, codec: codec, subs: make(map[<-chan *TypedChange[V]]*typedChangeSub[V])}
+}
+
+// JSONCodec returns a codec that encodes values using JSON.
+func JSONCodec[V any]() Codec[V] {
+	return jsonCodec[V]{}
+}
+
+// GobCodec returns a codec that encodes values using gob.
+func GobCodec[V any]() Codec[V] {
+	return gobCodec[V]{}
+}
+
+// BytesCodec returns a codec that stores byte slices as is.
+func BytesCodec() Codec[[]byte] {
+	return bytesCodec{}
+}
+
+// Untyped returns the underlying map.
+func (tm *TypedMap[V]) Untyped() *Map {
+	return tm.m
+}
+
+// Get returns the decoded value for the given key. An error is returned if
+// the value cannot be decoded.
+func (tm *TypedMap[V]) Get(key string) (V, bool, error) {
+	var zero V
+	val, ok := tm.m.Get(key)
+	if !ok {
+		return zero, false, nil
+	}
+	v, err := tm.decode(key, val)
+	if err != nil {
+		return zero, true, err
+	}
+	return v, true, nil
+}
+
+// Keys returns a copy of the replicated map keys.
+func (tm *TypedMap[V]) Keys() []string {
+	return tm.m.Keys()
+}
+
+// Len returns the number of items in the replicated map.
+func (tm *TypedMap[V]) Len() int {
+	return tm.m.Len()
+}
+
+// Map returns a copy of the replicated map content with decoded values. An
+// error is returned if any value cannot be decoded.
+func (tm *TypedMap[V]) Map() (map[string]V, error) {
+	content := tm.m.Map()
+	res := make(map[string]V, len(content))
+	for key, val := range content {
+		v, err := tm.decode(key, val)
+		if err != nil {
+			return nil, err
+		}
+		res[key] = v
+	}
+	return res, nil
+}
+
+// Set encodes and sets the value for the given key and returns the previous
+// value and whether the key existed, see Map.Set.
+func (tm *TypedMap[V]) Set(ctx context.Context, key string, value V) (V, bool, error) {
+	var zero V
+	val, err := tm.encode(key, value)
+	if err != nil {
+		return zero, false, err
+	}
+	prev, existed, err := tm.m.set(ctx, key, val)
+	if err != nil {
+		return zero, false, err
+	}
+	return tm.decodePrev(key, prev, existed)
+}
+
+// TestAndSet sets the value for the given key if the current value matches
+// test and returns the previous value and whether the key existed, see
+// Map.TestAndSet. Values are compared in their encoded form so the codec must
+// encode equal values identically.
+func (tm *TypedMap[V]) TestAndSet(ctx context.Context, key string, test, value V) (V, bool, error) {
+	var zero V
+	t, err := tm.encode(key, test)
+	if err != nil {
+		return zero, false, err
+	}
+	val, err := tm.encode(key, value)
+	if err != nil {
+		return zero, false, err
+	}
+	prev, existed, err := tm.m.testAndSet(ctx, key, t, val)
+	if err != nil {
+		return zero, false, err
+	}
+	return tm.decodePrev(key, prev, existed)
+}
+
+// Delete deletes the value for the given key and returns the previous value
+// and whether the key existed, see Map.Delete.
+func (tm *TypedMap[V]) Delete(ctx context.Context, key string) (V, bool, error) {
+	prev, existed, err := tm.m.delete(ctx, key)
+	if err != nil {
+		var zero V
+		return zero, false, err
+	}
+	return tm.decodePrev(key, prev, existed)
+}
+
+// Subscribe returns a channel that receives a record with the decoded values
+// for each change made to the map, see Map.SubscribeChanges. Changes whose
+// values cannot be decoded are delivered with Err set. The channel is closed
+// when the map is stopped or Unsubscribe is called. Subscribe returns nil if
+// the map is stopped.
+func (tm *TypedMap[V]) Subscribe() <-chan *TypedChange[V] {
+	raw := tm.m.SubscribeChanges()
+	if raw == nil {
+		return nil
+	}
+	sub := &typedChangeSub[V]{
+		raw:  raw,
+		c:    make(chan *TypedChange[V]),
+		done: make(chan struct{}),
+	}
+	tm.lock.Lock()
+	tm.subs[sub.c] = sub
+	tm.lock.Unlock()
+	pulse.Go(context.Background(), func() { tm.forward(sub) })
+	return sub.c
+}
+
+// Unsubscribe removes the given channel from the list of subscribers and
+// closes it.
+func (tm *TypedMap[V]) Unsubscribe(c <-chan *TypedChange[V]) {
+	tm.lock.Lock()
+	sub, ok := tm.subs[c]
+	delete(tm.subs, c)
+	tm.lock.Unlock()
+	if !ok {
+		return
+	}
+	tm.m.UnsubscribeChanges(sub.raw)
+	close(sub.done)
+}
+
+// forward decodes the changes received on the underlying subscription and
+// sends them to the typed subscription channel until either subscription is
+// closed.
+func (tm *TypedMap[V]) forward(sub *typedChangeSub[V]) {
+	defer close(sub.c)
+	for change := range sub.raw {
+		tc := &TypedChange[V]{
+			Kind:     change.Kind,
+			Key:      change.Key,
+			Existed:  change.Existed,
+			Origin:   change.Origin,
+			Revision: change.Revision,
+		}
+		tc.Prev, _, tc.Err = tm.decodePrev(change.Key, change.Prev, change.Existed)
+		if tc.Err == nil && change.Kind == EventChange {
+			tc.Value, tc.Err = tm.decode(change.Key, change.Value)
+		}
+		select {
+		case sub.c <- tc:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// encode encodes the value for the given key.
+func (tm *TypedMap[V]) encode(key string, value V) (string, error) {
+	val, err := tm.codec.Encode(value)
+	if err != nil {
+		return "", fmt.Errorf("pulse map: %s failed to encode value for key %s: %w", tm.m.Name, key, err)
+	}
+	return val, nil
+}
+
+// decode decodes the value for the given key.
+func (tm *TypedMap[V]) decode(key, val string) (V, error) {
+	v, err := tm.codec.Decode(val)
+	if err != nil {
+		return v, fmt.Errorf("pulse map: %s failed to decode value for key %s: %w", tm.m.Name, key, err)
+	}
+	return v, nil
+}
+
+// decodePrev decodes a previous value returned by a write or recorded in a
+// change, the zero value is returned if the key did not exist.
+func (tm *TypedMap[V]) decodePrev(key, prev string, existed bool) (V, bool, error) {
+	if !existed {
+		var zero V
+		return zero, false, nil
+	}
+	v, err := tm.decode(key, prev)
+	return v, true, err
+}
+
+func (jsonCodec[V]) Encode(v V) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (jsonCodec[V]) Decode(s string) (V, error) {
+	var v V
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+func (gobCodec[V]) Encode(v V) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (gobCodec[V]) Decode(s string) (V, error) {
+	var v V
+	err := gob.NewDecoder(bytes.NewBufferString(s)).Decode(&v)
+	return v, err
+}
+
+func (bytesCodec) Encode(v []byte) (string, error) {
+	return string(v), nil
+}
+
+func (bytesCodec) Decode(s string) ([]byte, error) {
+	return []byte(s), nil
+}
@@ -0,0 +1,137 @@
+package rmap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Name    string
+	Enabled bool
+}
+
+func TestTypedMap(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	tm := NewTypedMap(m, JSONCodec[testConfig]())
+	assert.Equal(t, m, tm.Untyped())
+	c := tm.Subscribe()
+
+	_, ok, err := tm.Get("foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	foo := testConfig{Name: "foo", Enabled: true}
+	prev, existed, err := tm.Set(ctx, "foo", foo)
+	assert.NoError(t, err)
+	assert.False(t, existed)
+	assert.Equal(t, testConfig{}, prev)
+	assert.Eventually(t, func() bool { return tm.Len() == 1 }, wf, tck)
+	change := <-c
+	assert.Equal(t, EventChange, change.Kind)
+	assert.Equal(t, "foo", change.Key)
+	assert.Equal(t, foo, change.Value)
+	assert.False(t, change.Existed)
+	assert.Positive(t, change.Revision)
+	assert.NoError(t, change.Err)
+	v, ok, err := tm.Get("foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, foo, v)
+
+	bar := testConfig{Name: "bar"}
+	prev, existed, err = tm.TestAndSet(ctx, "foo", testConfig{Name: "other"}, bar)
+	assert.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, foo, prev)
+	prev, _, err = tm.TestAndSet(ctx, "foo", foo, bar)
+	assert.NoError(t, err)
+	assert.Equal(t, foo, prev)
+	change = <-c
+	assert.Equal(t, foo, change.Prev)
+	assert.True(t, change.Existed)
+	assert.Equal(t, bar, change.Value)
+	assert.Eventually(t, func() bool { v, _, _ := tm.Get("foo"); return v == bar }, wf, tck)
+	content, err := tm.Map()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]testConfig{"foo": bar}, content)
+
+	// Decoding errors are surfaced
+	_, err = m.Set(ctx, "invalid", "not json")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return tm.Len() == 2 }, wf, tck)
+	_, ok, err = tm.Get("invalid")
+	assert.True(t, ok)
+	assert.ErrorContains(t, err, "invalid")
+	_, err = tm.Map()
+	assert.Error(t, err)
+	change = <-c
+	assert.Equal(t, "invalid", change.Key)
+	assert.ErrorContains(t, change.Err, "invalid")
+
+	prev, existed, err = tm.Delete(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, bar, prev)
+	change = <-c
+	assert.Equal(t, EventDelete, change.Kind)
+	assert.Equal(t, bar, change.Prev)
+	tm.Unsubscribe(c)
+	_, ok = <-c
+	assert.False(t, ok)
+}
+
+func TestTypedMapEmptyValue(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	tm := NewTypedMap(m, BytesCodec())
+
+	// Empty values are distinguished from missing keys
+	_, existed, err := tm.Set(ctx, "foo", []byte{})
+	require.NoError(t, err)
+	assert.False(t, existed)
+	prev, existed, err := tm.Set(ctx, "foo", []byte("bar"))
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, []byte{}, prev)
+}
+
+func TestCodecs(t *testing.T) {
+	cfg := testConfig{Name: "foo", Enabled: true}
+
+	jc := JSONCodec[testConfig]()
+	s, err := jc.Encode(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"foo","Enabled":true}`, s)
+	v, err := jc.Decode(s)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, v)
+	_, err = jc.Decode("not json")
+	assert.Error(t, err)
+
+	gc := GobCodec[testConfig]()
+	s, err = gc.Encode(cfg)
+	require.NoError(t, err)
+	v, err = gc.Decode(s)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, v)
+	_, err = gc.Decode("not gob")
+	assert.Error(t, err)
+
+	bc := BytesCodec()
+	s, err = bc.Encode([]byte{0, 1, 2})
+	require.NoError(t, err)
+	b, err := bc.Decode(s)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, b)
+}
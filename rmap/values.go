@@ -0,0 +1,119 @@
+package rmap
+
+import (
+	"context"
+	"strings"
+)
+
+// valuesEscaper escapes the separator and escape characters of array items.
+var valuesEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`)
+
+// EncodeValues encodes the given items into an array value as stored by
+// AppendValues, AppendUniqueValues and RemoveValues. Items are separated by
+// commas, commas and backslashes in items are escaped with a backslash. Comma
+// separated lists whose items do not contain backslashes are thus valid
+// encodings. EncodeValues returns an empty string for an empty list and for a
+// list that contains a single empty item.
+func EncodeValues(items []string) string {
+	escaped := make([]string, len(items))
+	for i, item := range items {
+		escaped[i] = valuesEscaper.Replace(item)
+	}
+	return strings.Join(escaped, ",")
+}
+
+// DecodeValues decodes an array value encoded with EncodeValues. An empty
+// string decodes to an empty list.
+func DecodeValues(value string) []string {
+	if value == "" {
+		return nil
+	}
+	if !strings.ContainsRune(value, '\\') {
+		return strings.Split(value, ",")
+	}
+	var (
+		items []string
+		cur   strings.Builder
+	)
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+				cur.WriteByte(value[i])
+			}
+		case ',':
+			items = append(items, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(value[i])
+		}
+	}
+	return append(items, cur.String())
+}
+
+// ValuesLen returns the number of items in the array value for the given key,
+// 0 if the key does not exist.
+func (sm *Map) ValuesLen(key string) int {
+	vals, _ := sm.GetValues(key)
+	return len(vals)
+}
+
+// ContainsValue returns true if the array value for the given key contains
+// item.
+func (sm *Map) ContainsValue(key, item string) bool {
+	vals, _ := sm.GetValues(key)
+	for _, v := range vals {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// PopValue removes the first item from the array value for the given key and
+// returns it. The key is deleted if the array becomes empty. PopValue returns
+// false if the key does not exist.
+// An error is returned if:
+// - The key is empty
+// - The key contains an equal sign
+// - There's an issue with the Redis operation
+func (sm *Map) PopValue(ctx context.Context, key string) (string, bool, error) {
+	res, err := sm.runLuaScript(ctx, "pop", sm.popScript, key)
+	if err != nil {
+		return "", false, err
+	}
+	if res == nil {
+		return "", false, nil
+	}
+	return res.(string), true, nil
+}
+
+// MigrateValues re-encodes an array value written by a version of this
+// package that did not escape items. Such values are comma separated lists
+// whose items may contain backslashes, which would otherwise be interpreted
+// as escape characters. MigrateValues is a no-op if the value does not
+// contain backslashes and thus decodes identically with both encodings. It
+// returns false if the key does not exist or was modified concurrently.
+// MigrateValues must only be called on values that have not been written
+// since the upgrade.
+func (sm *Map) MigrateValues(ctx context.Context, key string) (bool, error) {
+	val, ok := sm.Get(key)
+	if !ok {
+		return false, nil
+	}
+	if !strings.ContainsRune(val, '\\') {
+		return true, nil
+	}
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item != "" { // Legacy scripts ignored empty items
+			items = append(items, item)
+		}
+	}
+	prev, err := sm.TestAndSet(ctx, key, val, EncodeValues(items))
+	if err != nil {
+		return false, err
+	}
+	return prev == val, nil
+}
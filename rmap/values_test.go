@@ -0,0 +1,116 @@
+package rmap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeValues(t *testing.T) {
+	cases := []struct {
+		name    string
+		items   []string
+		encoded string
+	}{
+		{"empty", nil, ""},
+		{"single", []string{"a"}, "a"},
+		{"multiple", []string{"a", "b", "c"}, "a,b,c"},
+		{"comma", []string{"a,b", "c"}, `a\,b,c`},
+		{"backslash", []string{`a\`, `\b`}, `a\\,\\b`},
+		{"empty items", []string{"a", "", "b"}, "a,,b"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.encoded, EncodeValues(c.items))
+			assert.Equal(t, c.items, DecodeValues(c.encoded))
+		})
+	}
+	assert.Equal(t, []string{"a"}, DecodeValues(`a\`), "trailing escape character is ignored")
+}
+
+func TestListValues(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	const key = "list"
+
+	// Items may contain commas and backslashes
+	items := []string{"a,b", `c\d`, "e"}
+	res, err := m.AppendValues(ctx, key, items[:2]...)
+	require.NoError(t, err)
+	assert.Equal(t, items[:2], res)
+	res, err = m.AppendUniqueValues(ctx, key, "a,b", "e", "e")
+	require.NoError(t, err)
+	assert.Equal(t, items, res)
+	assert.Eventually(t, func() bool { return m.ValuesLen(key) == 3 }, wf, tck)
+	vals, ok := m.GetValues(key)
+	assert.True(t, ok)
+	assert.Equal(t, items, vals)
+	assert.True(t, m.ContainsValue(key, "a,b"))
+	assert.False(t, m.ContainsValue(key, "a"))
+
+	// Removal preserves order
+	res, removed, err := m.RemoveValues(ctx, key, `c\d`)
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.Equal(t, []string{"a,b", "e"}, res)
+
+	// Pop removes the first item and deletes the key when empty
+	item, ok, err := m.PopValue(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a,b", item)
+	item, ok, err = m.PopValue(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "e", item)
+	assert.Eventually(t, func() bool { _, ok := m.Get(key); return !ok }, wf, tck)
+	_, ok, err = m.PopValue(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, m.ValuesLen(key))
+
+	// Batches encode appended items
+	_, err = m.Apply(ctx, NewBatch().Append(key, "x,y").Append(key, "z"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return m.ValuesLen(key) == 2 }, wf, tck)
+	vals, _ = m.GetValues(key)
+	assert.Equal(t, []string{"x,y", "z"}, vals)
+}
+
+func TestMigrateValues(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+
+	// Legacy values without backslashes decode identically
+	_, err = m.Set(ctx, "plain", "a,b")
+	require.NoError(t, err)
+	_, err = m.Set(ctx, "legacy", `a\b,,c`)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return m.Len() == 2 }, wf, tck)
+	ok, err := m.MigrateValues(ctx, "plain")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	vals, _ := m.GetValues("plain")
+	assert.Equal(t, []string{"a", "b"}, vals)
+
+	// Legacy values with backslashes are re-encoded
+	ok, err = m.MigrateValues(ctx, "legacy")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Eventually(t, func() bool { v, _ := m.Get("legacy"); return v == `a\\b,c` }, wf, tck)
+	vals, _ = m.GetValues("legacy")
+	assert.Equal(t, []string{`a\b`, "c"}, vals)
+
+	ok, err = m.MigrateValues(ctx, "missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,62 @@
+package rmap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// WatchKey returns a channel that receives a record for each change made to
+// the given key. The channel also receives EventReset and EventResync changes
+// as these may affect the key. Changes are delivered in order and are never
+// coalesced, see SubscribeChanges. The channel is closed when the map is
+// stopped or UnsubscribeChanges is called. WatchKey returns nil if the map is
+// stopped.
+func (sm *Map) WatchKey(key string) <-chan *Change {
+	return sm.subscribeChanges(func(k string) bool { return k == key })
+}
+
+// WatchPrefix returns a channel that receives a record for each change made to
+// keys that start with the given prefix. The channel also receives EventReset
+// and EventResync changes as these may affect the keys. Changes are delivered
+// in order and are never coalesced, see SubscribeChanges. The channel is
+// closed when the map is stopped or UnsubscribeChanges is called. WatchPrefix
+// returns nil if the map is stopped.
+func (sm *Map) WatchPrefix(prefix string) <-chan *Change {
+	return sm.subscribeChanges(func(k string) bool { return strings.HasPrefix(k, prefix) })
+}
+
+// WaitFor blocks until the value of the given key satisfies predicate and
+// returns that value. predicate is called with the current value of the key
+// and whether the key exists, first when WaitFor is called and then each time
+// the key changes. An error is returned if the context is canceled or the map
+// is stopped before the predicate is satisfied.
+//
+// Example:
+//
+//	val, err := m.WaitFor(ctx, "status", func(v string, ok bool) bool { return v == "ready" })
+//
+// would block until the "status" key is set to "ready".
+func (sm *Map) WaitFor(ctx context.Context, key string, predicate func(value string, ok bool) bool) (string, error) {
+	c := sm.WatchKey(key)
+	if c == nil {
+		return "", fmt.Errorf("pulse map: %s is stopped", sm.Name)
+	}
+	defer sm.UnsubscribeChanges(c)
+	for {
+		// Read the local content rather than the change so that the
+		// predicate always sees the latest value, including after a reset
+		// or a resync.
+		if val, ok := sm.Get(key); predicate(val, ok) {
+			return val, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case _, ok := <-c:
+			if !ok {
+				return "", fmt.Errorf("pulse map: %s is stopped", sm.Name)
+			}
+		}
+	}
+}
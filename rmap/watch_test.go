@@ -0,0 +1,110 @@
+package rmap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchKey(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	c := m.WatchKey("foo")
+	require.NotNil(t, c)
+
+	_, err = m.Set(ctx, "bar", "1")
+	require.NoError(t, err)
+	_, err = m.Set(ctx, "foo", "2")
+	require.NoError(t, err)
+	_, err = m.Delete(ctx, "foo")
+	require.NoError(t, err)
+	require.NoError(t, m.Reset(ctx))
+
+	change := readChange(t, c)
+	assert.Equal(t, EventChange, change.Kind)
+	assert.Equal(t, "foo", change.Key)
+	assert.Equal(t, "2", change.Value)
+	change = readChange(t, c)
+	assert.Equal(t, EventDelete, change.Kind)
+	assert.Equal(t, "foo", change.Key)
+	assert.Equal(t, EventReset, readChange(t, c).Kind)
+
+	m.UnsubscribeChanges(c)
+	assert.Eventually(t, func() bool { _, ok := <-c; return !ok }, wf, tck)
+}
+
+func TestWatchPrefix(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+	c := m.WatchPrefix("jobs/")
+	require.NotNil(t, c)
+
+	_, err = m.Set(ctx, "workers/1", "1")
+	require.NoError(t, err)
+	_, err = m.Set(ctx, "jobs/1", "a")
+	require.NoError(t, err)
+	_, err = m.Set(ctx, "jobs/2", "b")
+	require.NoError(t, err)
+
+	assert.Equal(t, "jobs/1", readChange(t, c).Key)
+	assert.Equal(t, "jobs/2", readChange(t, c).Key)
+	select {
+	case change := <-c:
+		t.Errorf("unexpected change %v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: redisPwd})
+	ctx := context.Background()
+	m, err := Join(ctx, "test", rdb)
+	require.NoError(t, err)
+	defer cleanup(t, m)
+
+	// Predicate already satisfied
+	val, err := m.WaitFor(ctx, "status", func(_ string, ok bool) bool { return !ok })
+	assert.NoError(t, err)
+	assert.Equal(t, "", val)
+
+	// Predicate satisfied after updates
+	go func() {
+		for _, v := range []string{"starting", "ready"} {
+			_, err := m.Set(ctx, "status", v)
+			assert.NoError(t, err)
+		}
+	}()
+	val, err = m.WaitFor(ctx, "status", func(v string, _ bool) bool { return v == "ready" })
+	assert.NoError(t, err)
+	assert.Equal(t, "ready", val)
+
+	// Context canceled
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = m.WaitFor(cctx, "status", func(v string, _ bool) bool { return v == "done" })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Map stopped
+	m.Close()
+	_, err = m.WaitFor(ctx, "status", func(v string, _ bool) bool { return v == "done" })
+	assert.Error(t, err)
+}
+
+func TestChangeSubMatches(t *testing.T) {
+	sub := &changeSub{match: func(key string) bool { return key == "foo" }}
+	assert.True(t, sub.matches(&Change{Kind: EventChange, Key: "foo"}))
+	assert.False(t, sub.matches(&Change{Kind: EventChange, Key: "bar"}))
+	assert.True(t, sub.matches(&Change{Kind: EventReset}))
+	assert.True(t, sub.matches(&Change{Kind: EventResync}))
+	assert.True(t, (&changeSub{}).matches(&Change{Kind: EventChange, Key: "bar"}))
+}
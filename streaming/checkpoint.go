@@ -0,0 +1,173 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Commit checkpoints the given event so that a durable reader created with
+// the same name resumes right after it, see options.WithReaderName. Commit
+// does nothing if the event is older than the last checkpoint of its stream.
+// Commit returns an error if the reader is not durable. Commit may be called
+// while the reader is blocked sending events to the subscription channels.
+func (r *Reader) Commit(ctx context.Context, ev *Event) error {
+	if r.name == "" {
+		return fmt.Errorf("cannot commit event %s: reader is not durable", ev.ID)
+	}
+	r.ckLock.Lock()
+	defer r.ckLock.Unlock()
+	return r.commit(ctx, map[string]string{ev.StreamName: ev.ID})
+}
+
+// checkpoint checkpoints the last events consumed by all the subscriptions.
+func (r *Reader) checkpoint(ctx context.Context) error {
+	r.ckLock.Lock()
+	defer r.ckLock.Unlock()
+	r.collectConsumed()
+	return r.commit(ctx, r.consumed)
+}
+
+// tracksConsumption returns true if the reader tracks the events consumed by
+// its subscriptions to checkpoint them periodically.
+func (r *Reader) tracksConsumption() bool {
+	return r.name != "" && r.checkpointInterval > 0
+}
+
+// track records that ev is about to be sent to the subscriptions.
+func (r *Reader) track(ev *Event) {
+	r.ckLock.Lock()
+	defer r.ckLock.Unlock()
+	r.sentCount++
+	ev.seq = r.sentCount
+	r.sent = append(r.sent, sentEvent{seq: ev.seq, stream: ev.StreamName, id: ev.ID})
+}
+
+// collectConsumed moves the events consumed by all the tracked subscriptions
+// from r.sent to r.consumed.
+// r.ckLock must be held.
+func (r *Reader) collectConsumed() {
+	var oldest uint64
+	for _, sub := range r.tracked {
+		if seq := sub.unconsumed(); oldest == 0 || seq < oldest {
+			oldest = seq
+		}
+	}
+	i := 0
+	for ; i < len(r.sent) && (oldest == 0 || r.sent[i].seq < oldest); i++ {
+		r.consumed[r.sent[i].stream] = r.sent[i].id
+	}
+	r.sent = r.sent[i:]
+}
+
+// commit writes the given cursors indexed by stream name skipping the ones
+// that are not newer than the last checkpoints.
+// r.ckLock must be held.
+func (r *Reader) commit(ctx context.Context, cursors map[string]string) error {
+	values := make(map[string]any, len(cursors))
+	for name, id := range cursors {
+		if !isEventID(id) {
+			continue
+		}
+		if last, ok := r.committed[name]; ok && compareEventIDs(id, last) <= 0 {
+			continue
+		}
+		values[name] = id
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if err := r.rdb.HSet(ctx, r.cursorsKey, values).Err(); err != nil {
+		err = fmt.Errorf("failed to commit cursors: %w", err)
+		r.logger.Error(err)
+		return err
+	}
+	for name, id := range values {
+		r.committed[name] = id.(string)
+	}
+	r.logger.Debug("committed", "cursors", values)
+	return nil
+}
+
+// loadCheckpoint returns the checkpointed cursor of the given stream, startID
+// if there is none.
+func (r *Reader) loadCheckpoint(ctx context.Context, stream *Stream, startID string) (string, error) {
+	id, err := r.rdb.HGet(ctx, r.cursorsKey, stream.Name).Result()
+	if err == redis.Nil {
+		return startID, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load checkpoint for stream %s: %w", stream.Name, err)
+	}
+	r.ckLock.Lock()
+	r.committed[stream.Name] = id
+	r.ckLock.Unlock()
+	r.logger.Info("resuming", "stream", stream.Name, "after", id)
+	return id, nil
+}
+
+// periodicCheckpoint checkpoints the reader cursors every checkpoint interval.
+func (r *Reader) periodicCheckpoint() {
+	defer r.wait.Done()
+	defer r.logger.Debug("periodicCheckpoint: exiting")
+	ticker := time.NewTicker(r.checkpointInterval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for {
+		select {
+		case <-ticker.C:
+			r.checkpoint(ctx) // nolint: errcheck
+		case <-r.donechan:
+			return
+		}
+	}
+}
+
+// isEventID returns true if id is a complete event ID (e.g. not "$").
+func isEventID(id string) bool {
+	_, _, ok := parseEventID(id)
+	return ok
+}
+
+// compareEventIDs returns -1 if a is older than b, 1 if a is newer than b and
+// 0 if they are equal. a and b must be valid event IDs.
+func compareEventIDs(a, b string) int {
+	ams, aseq, _ := parseEventID(a)
+	bms, bseq, _ := parseEventID(b)
+	switch {
+	case ams < bms || ams == bms && aseq < bseq:
+		return -1
+	case ams > bms || aseq > bseq:
+		return 1
+	}
+	return 0
+}
+
+// parseEventID returns the timestamp and sequence number of the given event
+// ID.
+func parseEventID(id string) (uint64, uint64, bool) {
+	mss, seqs, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(mss, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(seqs, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
+
+// readerCursorsKey is the key of the hash that stores the checkpoints of a
+// durable reader.
+func readerCursorsKey(name string) string {
+	return fmt.Sprintf("reader:%s:cursors", name)
+}
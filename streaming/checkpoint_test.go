@@ -0,0 +1,126 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestDurableReader(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+	opts := []options.Reader{
+		options.WithReaderName(testName),
+		options.WithReaderStartAtOldest(),
+		options.WithReaderBlockDuration(testBlockDuration),
+	}
+	for _, payload := range []string{"1", "2", "3"} {
+		_, err := s.Add(ctx, "event", []byte(payload))
+		require.NoError(t, err)
+	}
+
+	// Commit first event only
+	reader, err := s.NewReader(ctx, opts...)
+	require.NoError(t, err)
+	c := reader.Subscribe()
+	read := readOneReaderEvent(t, c)
+	assert.Equal(t, "1", string(read.Payload))
+	assert.NoError(t, reader.Commit(ctx, read))
+	read = readOneReaderEvent(t, c)
+	assert.Equal(t, "2", string(read.Payload))
+	reader.Close()
+
+	// Restarted reader resumes after the committed event
+	reader, err = s.NewReader(ctx, append(opts, options.WithReaderCheckpointInterval(testCheckIdlePeriod))...)
+	require.NoError(t, err)
+	c = reader.Subscribe()
+	read = readOneReaderEvent(t, c)
+	assert.Equal(t, "2", string(read.Payload))
+	read = readOneReaderEvent(t, c)
+	assert.Equal(t, "3", string(read.Payload))
+	assert.NoError(t, reader.Commit(ctx, &Event{ID: "0-1", StreamName: s.Name}), "older commits are ignored")
+	reader.Close()
+
+	// Periodic checkpoints recorded the last event consumed by the previous
+	// reader subscriber, the last received event may not have been processed
+	reader, err = s.NewReader(ctx, opts...)
+	require.NoError(t, err)
+	c = reader.Subscribe()
+	read = readOneReaderEvent(t, c)
+	assert.Equal(t, "3", string(read.Payload))
+	_, err = s.Add(ctx, "event", []byte("4"))
+	require.NoError(t, err)
+	read = readOneReaderEvent(t, c)
+	assert.Equal(t, "4", string(read.Payload))
+	reader.Close()
+
+	// Non-durable readers cannot commit
+	reader, err = s.NewReader(ctx, options.WithReaderBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	assert.Error(t, reader.Commit(ctx, read))
+	reader.Close()
+	assert.NoError(t, rdb.Del(ctx, readerCursorsKey(testName)).Err())
+}
+
+func TestCommitFullBuffer(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+	for _, payload := range []string{"1", "2", "3", "4"} {
+		_, err := s.Add(ctx, "event", []byte(payload))
+		require.NoError(t, err)
+	}
+	reader, err := s.NewReader(ctx,
+		options.WithReaderName(testName),
+		options.WithReaderStartAtOldest(),
+		options.WithReaderBufferSize(1),
+		options.WithReaderBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, rdb.Del(ctx, readerCursorsKey(testName)).Err()) }()
+	defer reader.Close()
+	c := reader.Subscribe()
+
+	// Commit must not wait for the reader blocked on the full channel
+	for _, payload := range []string{"1", "2", "3", "4"} {
+		read := readOneReaderEvent(t, c)
+		assert.Equal(t, payload, string(read.Payload))
+		assert.NoError(t, reader.Commit(ctx, read))
+	}
+}
+
+func TestCompareEventIDs(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1-0", "1-0", 0},
+		{"1-0", "2-0", -1},
+		{"2-0", "1-0", 1},
+		{"1-1", "1-2", -1},
+		{"1-2", "1-1", 1},
+		{"2-0", "1-5", 1},
+		{"10-0", "9-0", 1},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, compareEventIDs(c.a, c.b), "%s vs %s", c.a, c.b)
+	}
+	assert.True(t, isEventID("1-0"))
+	assert.False(t, isEventID("$"))
+	assert.False(t, isEventID("0"))
+	assert.False(t, isEventID("a-0"))
+}
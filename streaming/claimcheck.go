@@ -0,0 +1,301 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/oklog/ulid/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/streaming/options"
+)
+
+type (
+	// redisBlobStore is a blob store that stores payloads in Redis.
+	redisBlobStore struct {
+		rdb redis.UniversalClient
+	}
+
+	// fileBlobStore is a blob store that stores payloads in files.
+	fileBlobStore struct {
+		dir string
+	}
+)
+
+var (
+	_ options.BlobStore = (*redisBlobStore)(nil)
+	_ options.BlobStore = (*fileBlobStore)(nil)
+)
+
+const (
+	// blobRefKey is the key used to store the key of the offloaded event
+	// payload.
+	blobRefKey = "b"
+	// blobKeyPrefix is the prefix of the keys used by the Redis blob store.
+	blobKeyPrefix = "pulse:blob:"
+)
+
+// NewRedisBlobStore returns a blob store that stores payloads under separate
+// Redis keys. This is the store used by WithStreamClaimCheck by default.
+func NewRedisBlobStore(rdb redis.UniversalClient) options.BlobStore {
+	return &redisBlobStore{rdb: rdb}
+}
+
+// NewFileBlobStore returns a blob store that stores payloads as files in the
+// given directory, creating it if needed. All the processes that consume the
+// stream must have access to the directory, for example via a shared volume.
+func NewFileBlobStore(dir string) (options.BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store directory: %w", err)
+	}
+	return &fileBlobStore{dir: dir}, nil
+}
+
+// offload stores the event payload in the blob store if it exceeds the stream
+// claim-check threshold. It returns the stream entry values updated to
+// reference the stored payload and the key of the payload in the blob store,
+// empty if the payload was not offloaded.
+func (s *Stream) offload(ctx context.Context, values []any) ([]any, string, error) {
+	if s.claimCheckThreshold <= 0 {
+		return values, "", nil
+	}
+	for i := 0; i < len(values); i += 2 {
+		if values[i] != payloadKey {
+			continue
+		}
+		var payload []byte
+		switch v := values[i+1].(type) {
+		case []byte:
+			payload = v
+		case string:
+			payload = []byte(v)
+		}
+		if len(payload) <= s.claimCheckThreshold {
+			return values, "", nil
+		}
+		key := s.Name + ":" + ulid.Make().String()
+		if err := s.blobStore.Put(ctx, key, payload); err != nil {
+			return nil, "", fmt.Errorf("failed to store payload: %w", err)
+		}
+		offloaded := make([]any, 0, len(values)+2)
+		offloaded = append(offloaded, values...)
+		offloaded[i+1] = ""
+		return append(offloaded, blobRefKey, key), key, nil
+	}
+	return values, "", nil
+}
+
+// trackBlob records that the payload stored under the given blob key belongs
+// to the event with the given ID so that it gets deleted once the event is
+// trimmed from the stream, see collectBlobs. If the event was not added (id is
+// empty) then the payload is deleted right away.
+func (s *Stream) trackBlob(ctx context.Context, key, id string) {
+	if key == "" {
+		return
+	}
+	if id == "" {
+		if err := s.blobStore.Delete(ctx, key); err != nil {
+			s.logger.Error(fmt.Errorf("failed to delete payload: %w", err), "blob", key)
+		}
+		return
+	}
+	ms, _, _ := parseEventID(id)
+	if err := s.rdb.ZAdd(ctx, s.blobsKey, redis.Z{Score: float64(ms), Member: key}).Err(); err != nil {
+		s.logger.Error(fmt.Errorf("failed to track payload: %w", err), "blob", key, "id", id)
+	}
+}
+
+// trackScheduledBlob records that the payload stored under the given blob key
+// belongs to a scheduled event. The payload is tracked with an infinite score
+// so that collectBlobs keeps it until the scheduler adds the event to the
+// stream and records the time of its ID, see moveScheduledScript. It must be
+// called before the event is scheduled so that it does not overwrite that
+// time.
+func (s *Stream) trackScheduledBlob(ctx context.Context, key string) error {
+	if key == "" {
+		return nil
+	}
+	if err := s.rdb.ZAdd(ctx, s.blobsKey, redis.Z{Score: math.Inf(1), Member: key}).Err(); err != nil {
+		if err := s.blobStore.Delete(ctx, key); err != nil {
+			s.logger.Error(fmt.Errorf("failed to delete payload: %w", err), "blob", key)
+		}
+		return fmt.Errorf("failed to track payload: %w", err)
+	}
+	return nil
+}
+
+// untrackBlob deletes the payload stored under the given blob key and stops
+// tracking it. It is used when scheduling an event tracked with
+// trackScheduledBlob fails.
+func (s *Stream) untrackBlob(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	if err := s.rdb.ZRem(ctx, s.blobsKey, key).Err(); err != nil {
+		s.logger.Error(fmt.Errorf("failed to untrack payload: %w", err), "blob", key)
+	}
+	if err := s.blobStore.Delete(ctx, key); err != nil {
+		s.logger.Error(fmt.Errorf("failed to delete payload: %w", err), "blob", key)
+	}
+}
+
+// loadPayload returns the payload of the given stream entry, fetching it from
+// the blob store and decompressing it if needed.
+func (s *Stream) loadPayload(ctx context.Context, values map[string]any) ([]byte, error) {
+	values, err := s.inlinePayload(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+	return decodePayload(values)
+}
+
+// inlinePayload returns the stream entry values with the offloaded payload, if
+// any, fetched from the blob store. It is used to copy events to streams that
+// outlive the original payload such as dead-letter streams.
+func (s *Stream) inlinePayload(ctx context.Context, values map[string]any) (map[string]any, error) {
+	ref, ok := values[blobRefKey]
+	if !ok {
+		return values, nil
+	}
+	store := s.blobStore
+	if store == nil {
+		store = NewRedisBlobStore(s.rdb)
+	}
+	payload, err := store.Get(ctx, ref.(string))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payload %q: %w", ref, err)
+	}
+	inlined := make(map[string]any, len(values))
+	for k, v := range values {
+		inlined[k] = v
+	}
+	inlined[payloadKey] = string(payload)
+	delete(inlined, blobRefKey)
+	return inlined, nil
+}
+
+// collectBlobs deletes the offloaded payloads of the events that were trimmed
+// from the stream.
+func (s *Stream) collectBlobs(ctx context.Context) error {
+	// Payloads of events that are still scheduled are tracked with an
+	// infinite score, see trackScheduledBlob.
+	maxScore := "(+inf"
+	info, err := s.rdb.XInfoStream(ctx, s.key).Result()
+	switch {
+	case err != nil && !isNoSuchKeyErr(err):
+		return fmt.Errorf("failed to get stream info: %w", err)
+	case err != nil:
+		// Stream was deleted, delete all payloads of added events.
+	case info.Length == 0:
+		ms, _, _ := parseEventID(info.LastGeneratedID)
+		maxScore = strconv.FormatUint(ms, 10)
+	default:
+		ms, _, _ := parseEventID(info.FirstEntry.ID)
+		maxScore = "(" + strconv.FormatUint(ms, 10)
+	}
+	keys, err := s.rdb.ZRangeByScore(ctx, s.blobsKey, &redis.ZRangeBy{Min: "-inf", Max: maxScore}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list payloads: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := s.blobStore.Delete(ctx, keys...); err != nil {
+		return fmt.Errorf("failed to delete payloads: %w", err)
+	}
+	members := make([]any, len(keys))
+	for i, k := range keys {
+		members[i] = k
+	}
+	if err := s.rdb.ZRem(ctx, s.blobsKey, members...).Err(); err != nil {
+		return fmt.Errorf("failed to untrack payloads: %w", err)
+	}
+	s.logger.Debug("collected", "payloads", len(keys))
+	return nil
+}
+
+// deleteBlobs deletes all the offloaded payloads of the stream.
+func (s *Stream) deleteBlobs(ctx context.Context) error {
+	keys, err := s.rdb.ZRange(ctx, s.blobsKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list payloads: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := s.blobStore.Delete(ctx, keys...); err != nil {
+		return fmt.Errorf("failed to delete payloads: %w", err)
+	}
+	return nil
+}
+
+// Put stores data under key.
+func (bs *redisBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	return bs.rdb.Set(ctx, blobKeyPrefix+key, data, 0).Err()
+}
+
+// Get returns the data stored under key.
+func (bs *redisBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return bs.rdb.Get(ctx, blobKeyPrefix+key).Bytes()
+}
+
+// Delete deletes the data stored under the given keys.
+func (bs *redisBlobStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// Delete keys one by one as they may belong to different cluster slots.
+	_, err := bs.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, blobKeyPrefix+key)
+		}
+		return nil
+	})
+	return err
+}
+
+// Put stores data in the file corresponding to key.
+func (bs *fileBlobStore) Put(_ context.Context, key string, data []byte) error {
+	// Write to a temporary file first so that readers never see partial
+	// payloads.
+	tmp, err := os.CreateTemp(bs.dir, ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() // nolint: errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), bs.path(key))
+}
+
+// Get returns the content of the file corresponding to key.
+func (bs *fileBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	return os.ReadFile(bs.path(key))
+}
+
+// Delete deletes the files corresponding to the given keys.
+func (bs *fileBlobStore) Delete(_ context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := os.Remove(bs.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the path of the file corresponding to key.
+func (bs *fileBlobStore) path(key string) string {
+	return filepath.Join(bs.dir, url.PathEscape(key))
+}
@@ -0,0 +1,148 @@
+package streaming
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestClaimCheck(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb,
+		options.WithStreamClaimCheck(10),
+		options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink", options.WithSinkStartAtOldest(), options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	c := sink.Subscribe()
+
+	small := []byte("small")
+	large := []byte(strings.Repeat("large", 10))
+	_, err = s.Add(ctx, "event", small)
+	require.NoError(t, err)
+	_, err = s.Add(ctx, "event", large)
+	require.NoError(t, err)
+
+	// Only the large payload is offloaded
+	msgs, err := rdb.XRange(ctx, s.key, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.NotContains(t, msgs[0].Values, blobRefKey)
+	require.Contains(t, msgs[1].Values, blobRefKey)
+	assert.Equal(t, "", msgs[1].Values[payloadKey])
+	blob := msgs[1].Values[blobRefKey].(string)
+
+	// Payloads are fetched transparently
+	read := readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, small, read.Payload)
+	read = readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, large, read.Payload)
+
+	// Payload is kept until the event is trimmed
+	require.NoError(t, s.collectBlobs(ctx))
+	exists, err := rdb.Exists(ctx, blobKeyPrefix+blob).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+	require.NoError(t, rdb.XTrimMaxLen(ctx, s.key, 0).Err())
+	require.NoError(t, s.collectBlobs(ctx))
+	exists, err = rdb.Exists(ctx, blobKeyPrefix+blob).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+}
+
+func TestScheduledClaimCheck(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb,
+		options.WithStreamClaimCheck(10),
+		options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink", options.WithSinkStartAtOldest(), options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	c := sink.Subscribe()
+
+	large := []byte(strings.Repeat("large", 10))
+	_, err = s.Add(ctx, "event", large, options.WithDelay(time.Second))
+	require.NoError(t, err)
+
+	// Payload is offloaded and kept until the event is added to the stream
+	blobs, err := rdb.ZRangeWithScores(ctx, s.blobsKey, 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	assert.True(t, math.IsInf(blobs[0].Score, 1))
+	require.NoError(t, s.collectBlobs(ctx))
+	exists, err := rdb.Exists(ctx, blobKeyPrefix+blobs[0].Member.(string)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+
+	read := readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, large, read.Payload)
+	blobs, err = rdb.ZRangeWithScores(ctx, s.blobsKey, 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	assert.Equal(t, float64(read.CreatedAt().UnixMilli()), blobs[0].Score)
+}
+
+func TestLoadEvents(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+	s, err := NewStream("testLoadEvents", nil,
+		options.WithStreamClaimCheck(10),
+		options.WithStreamBlobStore(store))
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), "found", []byte("payload")))
+	msgs := []redis.XMessage{
+		{ID: "1-0", Values: map[string]any{nameKey: "event", payloadKey: "inline"}},
+		{ID: "2-0", Values: map[string]any{nameKey: "event", payloadKey: "", blobRefKey: "found"}},
+		{ID: "3-0", Values: map[string]any{nameKey: "event", payloadKey: "", blobRefKey: "missing"}},
+		{ID: "4-0", Values: map[string]any{nameKey: "event", payloadKey: "inline"}},
+	}
+
+	// Loading stops at the event whose payload cannot be fetched
+	events, n, err := loadEvents(context.Background(), s, nil, msgs, nil, nil, pulse.NoopLogger())
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, events, 2)
+	assert.Equal(t, []byte("inline"), events[0].Payload)
+	assert.Equal(t, []byte("payload"), events[1].Payload)
+
+	events, n, err = loadEvents(context.Background(), s, nil, msgs[n+1:], nil, nil, pulse.NoopLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, events, 1)
+	assert.Equal(t, "4-0", events[0].ID)
+}
+
+func TestFileBlobStore(t *testing.T) {
+	ctx := ptesting.NewTestContext(t)
+	store, err := NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	key := "{orders}.created:01HZ"
+	require.NoError(t, store.Put(ctx, key, []byte("payload")))
+	data, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), data)
+
+	require.NoError(t, store.Delete(ctx, key, "missing"))
+	_, err = store.Get(ctx, key)
+	assert.Error(t, err)
+}
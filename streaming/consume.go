@@ -0,0 +1,116 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+)
+
+// Handler is the function called by Sink.Consume to process events.
+type Handler func(context.Context, *Event) error
+
+// errHandlerPanic is the error recorded for events whose handler panicked.
+var errHandlerPanic = errors.New("handler panicked")
+
+// Consume subscribes to the sink and calls handler for each event until ctx is
+// canceled or the sink is closed. Events are processed concurrently by the
+// number of workers set with WithConsumeConcurrency. By default events are
+// acknowledged when handler returns nil and negatively acknowledged when it
+// returns an error or panics so that they are redelivered, see Nack. Handler
+// panics are recovered and logged. Once ctx is canceled Consume stops
+// processing new events, waits for the events being processed to complete and
+// negatively acknowledges the events that were received but not processed.
+// Handlers are called with a context that is not canceled when ctx is.
+func (s *Sink) Consume(ctx context.Context, handler Handler, opts ...options.Consume) error {
+	o := options.ParseConsumeOptions(opts...)
+	if o.Concurrency < 1 {
+		return fmt.Errorf("invalid concurrency %d, must be at least 1", o.Concurrency)
+	}
+	hctx := context.WithoutCancel(ctx)
+	c := s.Subscribe()
+	var unprocessed []*Event
+	if o.Ordered {
+		unprocessed = s.consumeOrdered(ctx, hctx, handler, c, o)
+	} else {
+		s.consumeUnordered(ctx, hctx, handler, c, o)
+	}
+
+	// Unsubscribe concurrently as the sink may be blocked sending to c.
+	pulse.Go(hctx, func() { s.Unsubscribe(c) })
+	for ev := range c {
+		unprocessed = append(unprocessed, ev)
+	}
+	if s.noAck || o.NoRetry {
+		return nil
+	}
+	for _, ev := range unprocessed {
+		if err := s.Nack(hctx, ev, 0); err != nil {
+			s.logger.Error(fmt.Errorf("failed to nack unprocessed event: %w", err), "event", ev.ID, "stream", ev.StreamName)
+		}
+	}
+	return nil
+}
+
+// consumeUnordered processes the events received on c concurrently until ctx
+// is canceled or c is closed.
+func (s *Sink) consumeUnordered(ctx, hctx context.Context, handler Handler, c <-chan *Event, o options.ConsumeOptions) {
+	var wg sync.WaitGroup
+	wg.Add(o.Concurrency)
+	for i := 0; i < o.Concurrency; i++ {
+		pulse.Go(ctx, func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ev, ok := <-c:
+					if !ok {
+						return
+					}
+					s.handle(hctx, handler, ev, o)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// handle calls handler with the given event and acknowledges it according to
+// the outcome.
+func (s *Sink) handle(ctx context.Context, handler Handler, ev *Event, o options.ConsumeOptions) {
+	err := runHandler(ctx, handler, ev)
+	if s.noAck {
+		if err != nil {
+			s.logger.Error(fmt.Errorf("failed to handle event: %w", err), "event", ev.ID, "stream", ev.StreamName)
+		}
+		return
+	}
+	if err == nil {
+		if !o.NoAutoAck {
+			s.Ack(ctx, ev) // nolint: errcheck
+		}
+		return
+	}
+	s.logger.Error(fmt.Errorf("failed to handle event: %w", err), "event", ev.ID, "stream", ev.StreamName)
+	s.RecordError(ctx, ev, err) // nolint: errcheck
+	if !o.NoRetry {
+		s.Nack(ctx, ev, o.RetryDelay) // nolint: errcheck
+	}
+}
+
+// runHandler calls handler with the given event and returns its error,
+// errHandlerPanic if it panicked.
+func runHandler(ctx context.Context, handler Handler, ev *Event) error {
+	err := errHandlerPanic
+	done := make(chan struct{})
+	pulse.Go(ctx, func() {
+		defer close(done)
+		err = handler(ctx, ev)
+	})
+	<-done
+	return err
+}
@@ -0,0 +1,92 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestConsume(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	var origStalePeriod time.Duration
+	origStalePeriod, checkIdlePeriod = checkIdlePeriod, testCheckIdlePeriod
+	defer func() { checkIdlePeriod = origStalePeriod }()
+
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkAckGracePeriod(time.Minute))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+
+	var lock sync.Mutex
+	calls := make(map[string]int)
+	handled := make(chan string, 10)
+	handler := func(_ context.Context, ev *Event) error {
+		lock.Lock()
+		calls[ev.EventName]++
+		n := calls[ev.EventName]
+		lock.Unlock()
+		switch {
+		case ev.EventName == "error" && n == 1:
+			return errors.New("boom")
+		case ev.EventName == "panic" && n == 1:
+			panic("boom")
+		}
+		handled <- ev.EventName
+		return nil
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- sink.Consume(cctx, handler, options.WithConsumeConcurrency(2)) }()
+
+	// Successfully handled events are acked, failed and panicking handlers
+	// cause redeliveries
+	for _, name := range []string{"ok", "error", "panic"} {
+		_, err := s.Add(ctx, name, []byte("payload"))
+		require.NoError(t, err)
+	}
+	var names []string
+	for i := 0; i < 3; i++ {
+		select {
+		case name := <-handled:
+			names = append(names, name)
+		case <-time.After(max):
+			t.Fatal("timeout waiting for event to be handled")
+		}
+	}
+	assert.ElementsMatch(t, []string{"ok", "error", "panic"}, names)
+	lock.Lock()
+	assert.Equal(t, map[string]int{"ok": 1, "error": 2, "panic": 2}, calls)
+	lock.Unlock()
+	assert.Eventually(t, func() bool {
+		pending, err := rdb.XPending(ctx, s.key, "sink").Result()
+		return err == nil && pending.Count == 0
+	}, max, delay)
+
+	// Canceling the context stops consumption
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(max):
+		t.Fatal("timeout waiting for Consume to return")
+	}
+
+	assert.Error(t, sink.Consume(ctx, handler, options.WithConsumeConcurrency(0)))
+}
@@ -0,0 +1,361 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/streaming/options"
+)
+
+// DeadLetter is an event that was moved to a sink dead-letter stream because
+// it exceeded the maximum number of deliveries.
+type DeadLetter struct {
+	// ID is the ID of the event in the dead-letter stream.
+	ID string
+	// StreamName is the name of the stream the event was originally added to.
+	StreamName string
+	// EventID is the ID of the event in the original stream.
+	EventID string
+	// SinkName is the name of the sink that failed to process the event.
+	SinkName string
+	// EventName is the producer-defined event name.
+	EventName string
+	// Topic is the producer-defined event topic if any, empty string if none.
+	Topic string
+	// Payload is the event payload.
+	Payload []byte
+	// OrderingKey is the producer-defined event ordering key if any, empty
+	// string if none.
+	OrderingKey string
+	// SchemaVersion is the producer-defined version of the payload schema
+	// if any, 0 if none.
+	SchemaVersion int
+	// Headers are the producer-defined event headers if any, nil if none.
+	Headers map[string]string
+	// Deliveries is the number of times the event was delivered.
+	Deliveries int64
+	// Error is the last error recorded with RecordError for the event if
+	// any, empty string if none.
+	Error string
+}
+
+const (
+	// deadLetterStreamKey is the key used to store the original stream name.
+	deadLetterStreamKey = "ds"
+	// deadLetterIDKey is the key used to store the original event ID.
+	deadLetterIDKey = "di"
+	// deadLetterSinkKey is the key used to store the sink name.
+	deadLetterSinkKey = "dk"
+	// deadLetterDeliveriesKey is the key used to store the delivery count.
+	deadLetterDeliveriesKey = "dc"
+	// deadLetterErrorKey is the key used to store the last error.
+	deadLetterErrorKey = "de"
+)
+
+// deadLetterScript is the script used to add an event to the dead-letter
+// stream. It records a marker for the event so that the event is added only
+// once even if acknowledging it in the original stream fails and it gets
+// claimed again, see deadLetterIdleMessages. The script returns the ID of the
+// event in the dead-letter stream.
+var deadLetterScript = redis.NewScript(`
+    local stream = KEYS[1]
+    local marker = KEYS[2]
+
+    local prev = redis.call("GET", marker)
+    if prev then
+        return prev
+    end
+    local args = {stream}
+    if tonumber(ARGV[2]) > 0 then
+        table.insert(args, "MAXLEN")
+        table.insert(args, "~")
+        table.insert(args, ARGV[2])
+    end
+    table.insert(args, "*")
+    for i = 3, #ARGV do
+        table.insert(args, ARGV[i])
+    end
+    local id = redis.call("XADD", unpack(args))
+    redis.call("SET", marker, id, "PX", ARGV[1])
+    return id
+`)
+
+// DeadLetterStream returns the stream that receives the events that exceed the
+// maximum number of deliveries, nil if the sink was not created with
+// WithSinkMaxDeliveries.
+func (s *Sink) DeadLetterStream() *Stream {
+	return s.deadLetter
+}
+
+// RecordError records the error that caused the processing of the event to
+// fail. The last error recorded for an event is stored with the event if it
+// is moved to the dead-letter stream. RecordError does nothing if the sink was
+// not created with WithSinkMaxDeliveries.
+func (s *Sink) RecordError(ctx context.Context, e *Event, err error) error {
+	if s.maxDeliveries == 0 || err == nil {
+		return nil
+	}
+	_, rerr := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.errorsKey, eventField(e.StreamName, e.ID), err.Error())
+		// Expire errors of events that are never acked nor dead-lettered.
+		pipe.PExpire(ctx, s.errorsKey, 2*time.Duration(s.maxDeliveries+1)*s.ackGracePeriod)
+		return nil
+	})
+	if rerr != nil {
+		rerr = fmt.Errorf("failed to record error for event %s: %w", e.ID, rerr)
+		s.logger.Error(rerr, "stream", e.StreamName)
+		return rerr
+	}
+	return nil
+}
+
+// DeadLetters returns up to count events stored in the dead-letter stream
+// starting with the event with the given ID, "-" to start with the oldest
+// event. It returns nil if the sink was not created with
+// WithSinkMaxDeliveries.
+func (s *Sink) DeadLetters(ctx context.Context, start string, count int64) ([]*DeadLetter, error) {
+	if s.deadLetter == nil {
+		return nil, nil
+	}
+	msgs, err := s.rdb.XRangeN(ctx, s.deadLetter.key, start, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	res := make([]*DeadLetter, len(msgs))
+	for i, msg := range msgs {
+		res[i] = newDeadLetter(msg)
+	}
+	return res, nil
+}
+
+// DeadLetter returns the event with the given ID stored in the dead-letter
+// stream, nil if there is no such event.
+func (s *Sink) DeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	if s.deadLetter == nil {
+		return nil, nil
+	}
+	msgs, err := s.rdb.XRange(ctx, s.deadLetter.key, id, id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter %s: %w", id, err)
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	return newDeadLetter(msgs[0]), nil
+}
+
+// RequeueDeadLetter adds the event with the given ID stored in the dead-letter
+// stream back to its original stream and removes it from the dead-letter
+// stream. It returns the ID of the new event in the original stream. The event
+// is added using the options of the sink stream so the original stream must
+// still be consumed by the sink, see AddStream.
+func (s *Sink) RequeueDeadLetter(ctx context.Context, id string) (string, error) {
+	dl, err := s.DeadLetter(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if dl == nil {
+		return "", fmt.Errorf("dead letter %s not found", id)
+	}
+	stream := s.stream(dl.StreamName)
+	if stream == nil {
+		return "", fmt.Errorf("failed to requeue dead letter %s: stream %s is not consumed by sink %s", id, dl.StreamName, s.Name)
+	}
+	var opts []options.AddEvent
+	if dl.Topic != "" {
+		opts = append(opts, options.WithTopic(dl.Topic))
+	}
+	if dl.OrderingKey != "" {
+		opts = append(opts, options.WithOrderingKey(dl.OrderingKey))
+	}
+	if dl.SchemaVersion != 0 {
+		opts = append(opts, options.WithSchemaVersion(dl.SchemaVersion))
+	}
+	if len(dl.Headers) > 0 {
+		opts = append(opts, options.WithHeaders(dl.Headers))
+	}
+	newID, err := stream.Add(ctx, dl.EventName, dl.Payload, opts...)
+	if err != nil {
+		return "", err
+	}
+	if err := s.deadLetter.Remove(ctx, id); err != nil {
+		return "", err
+	}
+	s.logger.Info("requeued", "dead-letter", id, "stream", dl.StreamName, "event", newID)
+	return newID, nil
+}
+
+// deadLetterIdleMessages moves the idle messages that exceeded the maximum
+// number of deliveries to the dead-letter stream. It pages through the entire
+// list of pending messages, maxPolled messages at a time.
+// s.lock must be held.
+func (s *Sink) deadLetterIdleMessages(ctx context.Context, stream *Stream) error {
+	start := "-"
+	for {
+		pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream.key,
+			Group:  s.Name,
+			Idle:   s.ackGracePeriod,
+			Start:  start,
+			End:    "+",
+			Count:  s.maxPolled,
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("failed to list pending messages: %w", err)
+		}
+		if err := s.deadLetterPending(ctx, stream, pending); err != nil {
+			return err
+		}
+		if int64(len(pending)) < s.maxPolled {
+			return nil
+		}
+		start = "(" + pending[len(pending)-1].ID
+	}
+}
+
+// deadLetterPending moves the given pending messages that exceeded the maximum
+// number of deliveries to the dead-letter stream.
+// s.lock must be held.
+func (s *Sink) deadLetterPending(ctx context.Context, stream *Stream, pending []redis.XPendingExt) error {
+	deliveries := make(map[string]int64)
+	var ids []string
+	for _, p := range pending {
+		if p.RetryCount >= s.maxDeliveries {
+			ids = append(ids, p.ID)
+			deliveries[p.ID] = p.RetryCount
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// Claim the messages first so that no other consumer processes them
+	// while they are being dead-lettered.
+	msgs, err := s.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   stream.key,
+		Group:    s.Name,
+		Consumer: s.consumer,
+		MinIdle:  s.ackGracePeriod,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to claim messages: %w", err)
+	}
+	// Markers must outlive the events being claimed again after a failed ack.
+	markerTTL := 2 * time.Duration(s.maxDeliveries+1) * s.ackGracePeriod
+	for _, msg := range msgs {
+		field := eventField(stream.Name, msg.ID)
+		lastErr, err := s.rdb.HGet(ctx, s.errorsKey, field).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to read last error for message %s: %w", msg.ID, err)
+		}
+		// Inline offloaded payloads as they are deleted once the event is
+		// trimmed from the original stream.
+		fields, err := stream.inlinePayload(ctx, msg.Values)
+		if err != nil {
+			s.logger.Error(fmt.Errorf("failed to fetch payload of message %s: %w", msg.ID, err))
+			fields = msg.Values
+		}
+		args := make([]any, 0, 2*len(fields)+12)
+		args = append(args, markerTTL.Milliseconds(), s.deadLetter.MaxLen)
+		for k, v := range fields {
+			args = append(args, k, v)
+		}
+		args = append(args,
+			deadLetterStreamKey, stream.Name,
+			deadLetterIDKey, msg.ID,
+			deadLetterSinkKey, s.Name,
+			deadLetterDeliveriesKey, deliveries[msg.ID],
+			deadLetterErrorKey, lastErr)
+		keys := []string{s.deadLetter.key, s.deadLetterMarkerKey(field)}
+		if err := deadLetterScript.Run(ctx, s.rdb, keys, args...).Err(); err != nil {
+			return fmt.Errorf("failed to add message %s to dead-letter stream: %w", msg.ID, err)
+		}
+		if err := s.rdb.XAck(ctx, stream.key, s.Name, msg.ID).Err(); err != nil {
+			return fmt.Errorf("failed to ack dead-lettered message %s: %w", msg.ID, err)
+		}
+		if err := s.rdb.HDel(ctx, s.errorsKey, field).Err(); err != nil {
+			s.logger.Error(fmt.Errorf("failed to delete last error for message %s: %w", msg.ID, err))
+		}
+		s.logger.Info("dead-lettered", "stream", stream.Name, "event", msg.ID, "deliveries", deliveries[msg.ID], "error", lastErr)
+	}
+	return nil
+}
+
+// stream returns the sink stream with the given name, nil if none.
+// s.lock must not be held.
+func (s *Sink) stream(name string) *Stream {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, stream := range s.streams {
+		if stream.Name == name {
+			return stream
+		}
+	}
+	return nil
+}
+
+// newDeadLetter creates a dead letter from a dead-letter stream message.
+func newDeadLetter(msg redis.XMessage) *DeadLetter {
+	str := func(key string) string {
+		if v, ok := msg.Values[key]; ok {
+			return v.(string)
+		}
+		return ""
+	}
+	deliveries, _ := strconv.ParseInt(str(deadLetterDeliveriesKey), 10, 64)
+	version, _ := strconv.Atoi(str(schemaVersionKey))
+	payload, err := decodePayload(msg.Values)
+	if err != nil {
+		// Keep the payload as stored so that it is not lost.
+		payload = []byte(str(payloadKey))
+	}
+	return &DeadLetter{
+		ID:            msg.ID,
+		StreamName:    str(deadLetterStreamKey),
+		EventID:       str(deadLetterIDKey),
+		SinkName:      str(deadLetterSinkKey),
+		EventName:     str(nameKey),
+		Topic:         str(topicKey),
+		Payload:       payload,
+		OrderingKey:   str(orderingKeyKey),
+		SchemaVersion: version,
+		Headers:       parseHeaders(msg.Values),
+		Deliveries:    deliveries,
+		Error:         str(deadLetterErrorKey),
+	}
+}
+
+// deadLetterMarkerKey returns the key used to record that the event identified
+// by the given field, see eventField, was added to the dead-letter stream.
+// The key belongs to the same Redis Cluster slot as the dead-letter stream.
+func (s *Sink) deadLetterMarkerKey(field string) string {
+	return sameSlotKey(s.deadLetter.key, ":dead-lettered:"+field)
+}
+
+// eventField returns the hash field or sorted set member used to store data
+// about the event with the given ID read from the given stream, such as its
+// last error or its next retry.
+func eventField(streamName, id string) string {
+	return streamName + "|" + id
+}
+
+// parseEventField returns the stream name and event ID encoded in the given
+// field.
+func parseEventField(field string) (string, string) {
+	i := strings.LastIndexByte(field, '|')
+	if i < 0 {
+		return "", field
+	}
+	return field[:i], field[i+1:]
+}
+
+// sinkErrorsKey is the key of the hash that stores the last errors recorded
+// for the events of a sink.
+func sinkErrorsKey(sink string) string {
+	return fmt.Sprintf("sink:%s:errors", sink)
+}
@@ -0,0 +1,186 @@
+package streaming
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestDeadLetter(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	var origStalePeriod time.Duration
+	origStalePeriod, checkIdlePeriod = checkIdlePeriod, testCheckIdlePeriod
+	defer func() { checkIdlePeriod = origStalePeriod }()
+
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	assert.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkAckGracePeriod(testAckDuration),
+		options.WithSinkMaxDeliveries(2),
+		options.WithSinkDeadLetterStream(testName+":dlq"))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	dlq := sink.DeadLetterStream()
+	require.NotNil(t, dlq)
+	defer func() { assert.NoError(t, dlq.Destroy(ctx)) }()
+
+	// Read event twice without acking it
+	c := sink.Subscribe()
+	id, err := s.Add(ctx, "event", []byte("payload"), options.WithTopic("topic"))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		select {
+		case read := <-c:
+			assert.Equal(t, id, read.ID)
+			assert.NoError(t, sink.RecordError(ctx, read, errors.New("boom")))
+		case <-time.After(4 * testAckDuration):
+			t.Fatalf("timeout waiting for delivery %d", i+1)
+		}
+	}
+
+	// Event is moved to the dead-letter stream instead of being redelivered
+	var dls []*DeadLetter
+	assert.Eventually(t, func() bool {
+		dls, err = sink.DeadLetters(ctx, "-", 10)
+		return err == nil && len(dls) == 1
+	}, max, delay)
+	require.Len(t, dls, 1)
+	dl := dls[0]
+	assert.Equal(t, testName, dl.StreamName)
+	assert.Equal(t, id, dl.EventID)
+	assert.Equal(t, "sink", dl.SinkName)
+	assert.Equal(t, "event", dl.EventName)
+	assert.Equal(t, "topic", dl.Topic)
+	assert.Equal(t, []byte("payload"), dl.Payload)
+	assert.Equal(t, int64(2), dl.Deliveries)
+	assert.Equal(t, "boom", dl.Error)
+	select {
+	case <-c:
+		t.Error("dead-lettered event redelivered")
+	case <-time.After(4 * testAckDuration):
+	}
+	got, err := sink.DeadLetter(ctx, dl.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dl, got)
+
+	// Requeued event is delivered again
+	newID, err := sink.RequeueDeadLetter(ctx, dl.ID)
+	require.NoError(t, err)
+	read := readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, newID, read.ID)
+	assert.Equal(t, "topic", read.Topic)
+	dls, err = sink.DeadLetters(ctx, "-", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, dls)
+	_, err = sink.RequeueDeadLetter(ctx, dl.ID)
+	assert.Error(t, err)
+}
+
+func TestDeadLetterOnce(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	dlq, err := NewStream(testName+":dlq", rdb)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, dlq.Destroy(ctx)) }()
+	keys := []string{dlq.key, sameSlotKey(dlq.key, ":dead-lettered:"+eventField(testName, "1-0"))}
+
+	// Events claimed again after a failed ack are not added twice
+	id, err := deadLetterScript.Run(ctx, rdb, keys, time.Minute.Milliseconds(), 0, nameKey, "event").Text()
+	require.NoError(t, err)
+	id2, err := deadLetterScript.Run(ctx, rdb, keys, time.Minute.Milliseconds(), 0, nameKey, "event").Text()
+	require.NoError(t, err)
+	assert.Equal(t, id, id2)
+	n, err := rdb.XLen(ctx, dlq.key).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestDeadLetterPages(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	var origStalePeriod time.Duration
+	origStalePeriod, checkIdlePeriod = checkIdlePeriod, time.Hour
+	defer func() { checkIdlePeriod = origStalePeriod }()
+
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkAckGracePeriod(testAckDuration),
+		options.WithSinkMaxPolled(1),
+		options.WithSinkMaxDeliveries(1))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	dlq := sink.DeadLetterStream()
+	defer func() { assert.NoError(t, dlq.Destroy(ctx)) }()
+
+	// Deliver more events than maxPolled without acking them
+	c := sink.Subscribe()
+	for i := 0; i < 3; i++ {
+		_, err := s.Add(ctx, "event", []byte("payload"))
+		require.NoError(t, err)
+		select {
+		case <-c:
+		case <-time.After(max):
+			t.Fatalf("timeout waiting for event %d", i+1)
+		}
+	}
+	time.Sleep(2 * testAckDuration)
+
+	// All idle events are dead-lettered, not only the first page
+	sink.lock.Lock()
+	err = sink.deadLetterIdleMessages(ctx, s)
+	sink.lock.Unlock()
+	require.NoError(t, err)
+	dls, err := sink.DeadLetters(ctx, "-", 10)
+	require.NoError(t, err)
+	assert.Len(t, dls, 3)
+}
+
+func TestRequeueDeadLetterRemovedStream(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	other, err := NewStream(testName+"_other", rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkMaxDeliveries(2))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	dlq := sink.DeadLetterStream()
+	defer func() { assert.NoError(t, dlq.Destroy(ctx)) }()
+
+	// Dead letters of streams no longer consumed by the sink are not requeued
+	id, err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: dlq.key,
+		Values: []any{nameKey, "event", payloadKey, "payload", deadLetterStreamKey, other.Name},
+	}).Result()
+	require.NoError(t, err)
+	_, err = sink.RequeueDeadLetter(ctx, id)
+	assert.ErrorContains(t, err, "not consumed")
+	n, err := rdb.XLen(ctx, other.key).Result()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,79 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/streaming/options"
+)
+
+// scheduleIdempotentScript is the script used to schedule events with an
+// idempotency key. It behaves like addScript but adds the encoded
+// event to the scheduled events sorted set instead of the stream and records
+// the ID of the scheduled event. The event is due ARGV[4] milliseconds after
+// the current Redis time, see scheduleScript.
+var scheduleIdempotentScript = redis.NewScript(`
+    local key = KEYS[1]
+    local scheduled = KEYS[2]
+    local stream = KEYS[3]
+    local window = ARGV[1]
+    local mustExist = ARGV[2] == "1"
+    local id = ARGV[3]
+
+    local prev = redis.call("GET", key)
+    if prev then
+        return {prev, 0}
+    end
+    if mustExist and redis.call("EXISTS", stream) == 0 then
+        return nil
+    end
+
+    local t = redis.call("TIME")
+    local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+    redis.call("ZADD", scheduled, now + tonumber(ARGV[4]), ARGV[5])
+    redis.call("SET", key, id, "PX", window)
+    return {id, 1}
+`)
+
+// scheduleIdempotent schedules the event with the given ID and encoding unless
+// an event with the same idempotency key was added or scheduled within the
+// idempotency window, in which case it returns the ID of that event. blob is
+// the key of the offloaded event payload if any.
+func (s *Stream) scheduleIdempotent(ctx context.Context, name, id, encoded, blob string, o options.AddEventOptions) (string, error) {
+	mustExist := "0"
+	if o.OnlyIfStreamExists {
+		mustExist = "1"
+	}
+	keys := []string{s.idempotencyKey(o.IdempotencyKey), s.scheduledKey, s.key}
+	args := []any{s.idempotencyWindow.Milliseconds(), mustExist, id, time.Until(o.DeliverAt).Milliseconds(), encoded}
+	res, err := scheduleIdempotentScript.Run(ctx, s.rdb, keys, args...).Slice()
+	if err == redis.Nil {
+		// Stream does not exist and OnlyIfStreamExists option was used.
+		s.untrackBlob(ctx, blob)
+		return "", nil
+	}
+	if err != nil {
+		s.untrackBlob(ctx, blob)
+		err = fmt.Errorf("failed to schedule event: %w", err)
+		s.logger.Error(err, "event", name, "idempotency-key", o.IdempotencyKey)
+		return "", err
+	}
+	id = res[0].(string)
+	if res[1].(int64) == 0 {
+		s.untrackBlob(ctx, blob)
+		s.logger.Info("duplicate", "event", name, "id", id, "idempotency-key", o.IdempotencyKey)
+		return id, nil
+	}
+	s.logger.Info("scheduled", "event", name, "id", id, "deliver_at", o.DeliverAt)
+	return id, nil
+}
+
+// idempotencyKey returns the key used to record the ID of the event added with
+// the given idempotency key.
+// The key belongs to the same Redis Cluster slot as the stream.
+func (s *Stream) idempotencyKey(key string) string {
+	return sameSlotKey(s.key, ":idempotency:"+key)
+}
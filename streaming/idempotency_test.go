@@ -0,0 +1,97 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestIdempotentAdd(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	window := 100 * time.Millisecond
+	s, err := NewStream(testName, rdb,
+		options.WithStreamIdempotencyWindow(window),
+		options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+
+	// Stream does not exist
+	id, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"), options.WithOnlyIfStreamExists())
+	require.NoError(t, err)
+	assert.Empty(t, id)
+
+	id1, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, id1)
+
+	// Duplicate returns original ID
+	id2, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, id1, id2)
+
+	// Different key adds a new event
+	id3, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("bar"))
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id3)
+
+	info, err := s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.Length)
+
+	// Key expires after the window
+	time.Sleep(2 * window)
+	id4, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"))
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id4)
+}
+
+func TestIdempotentSchedule(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+
+	id1, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"), options.WithDelay(time.Hour))
+	require.NoError(t, err)
+	assert.NotEmpty(t, id1)
+
+	// Retried scheduled event is not scheduled twice
+	id2, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"), options.WithDelay(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, id1, id2)
+	n, err := rdb.ZCard(ctx, s.scheduledKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// Immediate add with the same key is a duplicate too
+	id3, err := s.Add(ctx, "event", []byte("payload"), options.WithIdempotencyKey("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, id1, id3)
+}
+
+func TestIdempotencyKeySlot(t *testing.T) {
+	s, err := NewStream("orders", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "{pulse:stream:orders}:idempotency:foo", s.idempotencyKey("foo"))
+	s, err = NewStream("{orders}.created", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "pulse:stream:{orders}.created:idempotency:foo", s.idempotencyKey("foo"))
+}
+
+func TestInvalidIdempotencyWindow(t *testing.T) {
+	_, err := NewStream("test", nil, options.WithStreamIdempotencyWindow(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,266 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/streaming/options"
+)
+
+type (
+	// StreamInfo describes the state of a stream.
+	StreamInfo struct {
+		// Name is the stream name.
+		Name string
+		// Length is the number of events in the stream.
+		Length int64
+		// FirstID is the ID of the oldest event in the stream, empty
+		// string if the stream is empty.
+		FirstID string
+		// LastID is the ID of the newest event in the stream, empty
+		// string if the stream is empty.
+		LastID string
+		// Groups describes the consumer groups (i.e. sinks) of the
+		// stream.
+		Groups []*GroupInfo
+	}
+
+	// GroupInfo describes the state of a stream consumer group.
+	GroupInfo struct {
+		// Name is the group name, i.e. the sink name.
+		Name string
+		// Consumers is the number of consumers in the group.
+		Consumers int64
+		// Pending is the number of events delivered to the group but not
+		// acknowledged.
+		Pending int64
+		// LastDeliveredID is the ID of the last event delivered to the
+		// group.
+		LastDeliveredID string
+		// Lag is the number of events in the stream that were not
+		// delivered to the group yet. Lag requires Redis 7 or later and
+		// is 0 when Redis cannot determine it.
+		Lag int64
+	}
+
+	// SinkStats describes the state of a sink.
+	SinkStats struct {
+		// Name is the sink name.
+		Name string
+		// Lag is the number of events not delivered to the sink yet
+		// across all the sink streams, see GroupInfo.Lag.
+		Lag int64
+		// Pending is the number of events delivered to the sink but not
+		// acknowledged across all the sink streams.
+		Pending int64
+		// OldestPendingIdle is the largest OldestPendingIdle across all
+		// the sink streams, see SinkStreamStats.
+		OldestPendingIdle time.Duration
+		// Streams describes the state of the sink for each stream.
+		Streams []*SinkStreamStats
+	}
+
+	// SinkStreamStats describes the state of a sink for one of its streams.
+	SinkStreamStats struct {
+		// StreamName is the stream name.
+		StreamName string
+		// Lag is the number of events not delivered to the sink yet,
+		// see GroupInfo.Lag.
+		Lag int64
+		// Pending is the number of events delivered to the sink but not
+		// acknowledged.
+		Pending int64
+		// OldestPendingIdle is the time elapsed since the oldest pending
+		// event (the pending event with the smallest ID) was last
+		// delivered, 0 if there is none. Contrary to the age of the event
+		// it does not include the time the event spent in the stream or
+		// scheduled before being delivered.
+		OldestPendingIdle time.Duration
+		// Consumers describes the state of the sink consumers.
+		Consumers []*ConsumerStats
+	}
+
+	// ConsumerStats describes the state of a sink consumer.
+	ConsumerStats struct {
+		// Name is the consumer name.
+		Name string
+		// Pending is the number of events delivered to the consumer but
+		// not acknowledged.
+		Pending int64
+		// Idle is the time elapsed since the consumer last read or
+		// claimed events.
+		Idle time.Duration
+	}
+
+	// PendingEvent describes an event delivered to a sink but not
+	// acknowledged.
+	PendingEvent struct {
+		// ID is the event ID.
+		ID string
+		// StreamName is the name of the stream the event belongs to.
+		StreamName string
+		// Consumer is the name of the consumer the event was delivered
+		// to.
+		Consumer string
+		// Idle is the time elapsed since the event was last delivered.
+		Idle time.Duration
+		// Deliveries is the number of times the event was delivered.
+		Deliveries int64
+	}
+)
+
+// Info returns information about the stream including its consumer groups.
+func (s *Stream) Info(ctx context.Context) (*StreamInfo, error) {
+	info, err := s.rdb.XInfoStream(ctx, s.key).Result()
+	if err != nil {
+		if isNoSuchKeyErr(err) {
+			return &StreamInfo{Name: s.Name}, nil
+		}
+		return nil, fmt.Errorf("failed to get stream info: %w", err)
+	}
+	groups, err := s.rdb.XInfoGroups(ctx, s.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream groups info: %w", err)
+	}
+	res := &StreamInfo{
+		Name:    s.Name,
+		Length:  info.Length,
+		FirstID: info.FirstEntry.ID,
+		LastID:  info.LastEntry.ID,
+		Groups:  make([]*GroupInfo, len(groups)),
+	}
+	for i, g := range groups {
+		res.Groups[i] = &GroupInfo{
+			Name:            g.Name,
+			Consumers:       g.Consumers,
+			Pending:         g.Pending,
+			LastDeliveredID: g.LastDeliveredID,
+			Lag:             g.Lag,
+		}
+	}
+	return res, nil
+}
+
+// Stats returns the lag, pending events and consumer states of the sink.
+func (s *Sink) Stats(ctx context.Context) (*SinkStats, error) {
+	streams := s.currentStreams()
+	res := &SinkStats{Name: s.Name, Streams: make([]*SinkStreamStats, len(streams))}
+	for i, stream := range streams {
+		st, err := s.streamStats(ctx, stream)
+		if err != nil {
+			return nil, err
+		}
+		res.Streams[i] = st
+		res.Lag += st.Lag
+		res.Pending += st.Pending
+		if st.OldestPendingIdle > res.OldestPendingIdle {
+			res.OldestPendingIdle = st.OldestPendingIdle
+		}
+	}
+	return res, nil
+}
+
+// Pending lists the events delivered to the sink but not acknowledged along
+// with their delivery counts. By default Pending lists up to 100 events per
+// stream starting with the oldest, see the options.WithPendingXXX options.
+func (s *Sink) Pending(ctx context.Context, opts ...options.Pending) ([]*PendingEvent, error) {
+	o := options.ParsePendingOptions(opts...)
+	var res []*PendingEvent
+	for _, stream := range s.currentStreams() {
+		if o.Stream != "" && o.Stream != stream.Name {
+			continue
+		}
+		pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   stream.key,
+			Group:    s.Name,
+			Idle:     o.MinIdle,
+			Start:    o.Start,
+			End:      "+",
+			Count:    o.Count,
+			Consumer: o.Consumer,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pending events for stream %s: %w", stream.Name, err)
+		}
+		for _, p := range pending {
+			res = append(res, &PendingEvent{
+				ID:         p.ID,
+				StreamName: stream.Name,
+				Consumer:   p.Consumer,
+				Idle:       p.Idle,
+				Deliveries: p.RetryCount,
+			})
+		}
+	}
+	return res, nil
+}
+
+// streamStats returns the sink stats for the given stream.
+func (s *Sink) streamStats(ctx context.Context, stream *Stream) (*SinkStreamStats, error) {
+	groups, err := s.rdb.XInfoGroups(ctx, stream.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups info for stream %s: %w", stream.Name, err)
+	}
+	res := &SinkStreamStats{StreamName: stream.Name}
+	for _, g := range groups {
+		if g.Name == s.Name {
+			res.Lag = g.Lag
+			res.Pending = g.Pending
+			break
+		}
+	}
+	if res.Pending > 0 {
+		pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream.key,
+			Group:  s.Name,
+			Start:  "-",
+			End:    "+",
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pending events for stream %s: %w", stream.Name, err)
+		}
+		if len(pending) > 0 {
+			res.OldestPendingIdle = pending[0].Idle
+		}
+	}
+	consumers, err := s.rdb.XInfoConsumers(ctx, stream.key, s.Name).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consumers info for stream %s: %w", stream.Name, err)
+	}
+	res.Consumers = make([]*ConsumerStats, len(consumers))
+	for i, c := range consumers {
+		res.Consumers[i] = &ConsumerStats{Name: c.Name, Pending: c.Pending, Idle: c.Idle}
+	}
+	sort.Slice(res.Consumers, func(i, j int) bool { return res.Consumers[i].Name < res.Consumers[j].Name })
+	return res, nil
+}
+
+// currentStreams returns the sink streams. It does not take the sink lock so
+// that introspection does not wait for the read loop to deliver events.
+func (s *Sink) currentStreams() []*Stream {
+	s.streamsLock.RLock()
+	defer s.streamsLock.RUnlock()
+	return s.streamsSnapshot
+}
+
+// snapshotStreams updates the copy of the sink streams returned by
+// currentStreams. s.lock must be held.
+func (s *Sink) snapshotStreams() {
+	streams := make([]*Stream, len(s.streams))
+	copy(streams, s.streams)
+	s.streamsLock.Lock()
+	s.streamsSnapshot = streams
+	s.streamsLock.Unlock()
+}
+
+// isNoSuchKeyErr returns true if the error is returned by XINFO when the
+// stream does not exist.
+func isNoSuchKeyErr(err error) bool {
+	return strings.Contains(err.Error(), "no such key")
+}
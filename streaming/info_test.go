@@ -0,0 +1,112 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestStreamInfo(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+
+	// Stream does not exist yet
+	info, err := s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &StreamInfo{Name: testName}, info)
+
+	sink, err := s.NewSink(ctx, "sink", options.WithSinkStartAtOldest(), options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	id1, err := s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+	id2, err := s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+
+	info, err = s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.Length)
+	assert.Equal(t, id1, info.FirstID)
+	assert.Equal(t, id2, info.LastID)
+	require.Len(t, info.Groups, 1)
+	assert.Equal(t, "sink", info.Groups[0].Name)
+}
+
+func TestSinkStats(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink", options.WithSinkStartAtOldest(), options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+
+	c := sink.Subscribe()
+	id, err := s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+	var read *Event
+	select {
+	case read = <-c:
+	case <-time.After(max):
+		t.Fatal("timeout waiting for event")
+	}
+
+	// Event is pending until acked
+	stats, err := sink.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "sink", stats.Name)
+	assert.Equal(t, int64(1), stats.Pending)
+	assert.Greater(t, stats.OldestPendingIdle, time.Duration(0))
+	require.Len(t, stats.Streams, 1)
+	assert.Equal(t, testName, stats.Streams[0].StreamName)
+	require.Len(t, stats.Streams[0].Consumers, 1)
+	assert.Equal(t, int64(1), stats.Streams[0].Consumers[0].Pending)
+
+	pending, err := sink.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, id, pending[0].ID)
+	assert.Equal(t, testName, pending[0].StreamName)
+	assert.Equal(t, stats.Streams[0].Consumers[0].Name, pending[0].Consumer)
+	assert.Equal(t, int64(1), pending[0].Deliveries)
+	pending, err = sink.Pending(ctx, options.WithPendingMinIdle(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	require.NoError(t, sink.Ack(ctx, read))
+	stats, err = sink.Stats(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Pending)
+	assert.Zero(t, stats.OldestPendingIdle)
+}
+
+func TestCurrentStreamsWithoutLock(t *testing.T) {
+	stream := &Stream{Name: "stream"}
+	s := &Sink{streams: []*Stream{stream}}
+	s.lock.Lock()
+	s.snapshotStreams()
+	defer s.lock.Unlock()
+
+	// The read loop holds the sink lock while sending events
+	done := make(chan []*Stream)
+	go func() { done <- s.currentStreams() }()
+	select {
+	case streams := <-done:
+		assert.Equal(t, []*Stream{stream}, streams)
+	case <-time.After(max):
+		t.Fatal("timeout waiting for streams")
+	}
+}
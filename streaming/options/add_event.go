@@ -1,11 +1,18 @@
 package options
 
+import "time"
+
 type (
 	// AddEvent is an option for adding an event to a stream.
 	AddEvent func(*AddEventOptions)
 
 	AddEventOptions struct {
 		Topic              string
+		Headers            map[string]string
+		DeliverAt          time.Time
+		OrderingKey        string
+		IdempotencyKey     string
+		SchemaVersion      int
 		OnlyIfStreamExists bool
 	}
 )
@@ -17,6 +24,71 @@ func WithTopic(topic string) AddEvent {
 	}
 }
 
+// WithHeaders sets headers for the added event. Headers carry metadata such as
+// correlation IDs or content types separately from the payload. WithHeaders may
+// be used multiple times, the headers are merged.
+func WithHeaders(headers map[string]string) AddEvent {
+	return func(o *AddEventOptions) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			o.Headers[k] = v
+		}
+	}
+}
+
+// WithDeliverAt delays the delivery of the added event until the given time.
+// The event is stored durably in Redis until it is due and then added to the
+// stream by one of the sinks or readers of the stream: events that become due
+// while none is running are added once one starts. The delivery time is
+// converted to a delay using the local clock when the event is added and
+// measured with the Redis clock afterwards. Only one of WithDeliverAt or
+// WithDelay can be used.
+func WithDeliverAt(t time.Time) AddEvent {
+	return func(o *AddEventOptions) {
+		o.DeliverAt = t
+	}
+}
+
+// WithDelay delays the delivery of the added event by the given duration, see
+// WithDeliverAt. Only one of WithDeliverAt or WithDelay can be used.
+func WithDelay(d time.Duration) AddEvent {
+	return func(o *AddEventOptions) {
+		o.DeliverAt = time.Now().Add(d)
+	}
+}
+
+// WithOrderingKey sets the ordering key of the added event. Sinks consuming
+// events with WithConsumeOrdered process events with the same ordering key
+// serially and in order.
+func WithOrderingKey(key string) AddEvent {
+	return func(o *AddEventOptions) {
+		o.OrderingKey = key
+	}
+}
+
+// WithIdempotencyKey sets the idempotency key of the added event. Adding an
+// event with the same idempotency key as an event added to the same stream
+// within the stream idempotency window (see WithStreamIdempotencyWindow) does
+// not add a new event and returns the ID of the original event instead. This
+// also applies to events scheduled for later delivery (see WithDelay and
+// WithDeliverAt) in which case the returned ID is the scheduled event ID.
+func WithIdempotencyKey(key string) AddEvent {
+	return func(o *AddEventOptions) {
+		o.IdempotencyKey = key
+	}
+}
+
+// WithSchemaVersion records the version of the schema used to encode the
+// payload of the added event. Consumers can read the version from the event to
+// decode the payload accordingly.
+func WithSchemaVersion(version int) AddEvent {
+	return func(o *AddEventOptions) {
+		o.SchemaVersion = version
+	}
+}
+
 // WithOnlyIfStreamExists only adds the event if the stream exists.
 func WithOnlyIfStreamExists() AddEvent {
 	return func(o *AddEventOptions) {
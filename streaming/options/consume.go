@@ -0,0 +1,80 @@
+package options
+
+import "time"
+
+type (
+	// Consume is an option for consuming events from a sink.
+	Consume func(*ConsumeOptions)
+
+	ConsumeOptions struct {
+		Concurrency int
+		NoAutoAck   bool
+		NoRetry     bool
+		RetryDelay  time.Duration
+		Ordered     bool
+	}
+)
+
+// WithConsumeConcurrency sets the number of events processed concurrently by
+// the handler. The default concurrency is 1.
+func WithConsumeConcurrency(n int) Consume {
+	return func(o *ConsumeOptions) {
+		o.Concurrency = n
+	}
+}
+
+// WithConsumeNoAutoAck prevents events from being acknowledged automatically
+// when the handler returns nil. The handler is then responsible for
+// acknowledging events.
+func WithConsumeNoAutoAck() Consume {
+	return func(o *ConsumeOptions) {
+		o.NoAutoAck = true
+	}
+}
+
+// WithConsumeRetryDelay sets the delay after which events whose handler
+// returned an error or panicked are redelivered. The default is 0 which means
+// the delay is computed by the sink retry backoff policy, see
+// WithSinkRetryBackoff.
+func WithConsumeRetryDelay(d time.Duration) Consume {
+	return func(o *ConsumeOptions) {
+		o.RetryDelay = d
+	}
+}
+
+// WithConsumeNoRetry prevents events whose handler returned an error or
+// panicked from being negatively acknowledged. Such events are then
+// redelivered once the sink ack grace period elapses.
+func WithConsumeNoRetry() Consume {
+	return func(o *ConsumeOptions) {
+		o.NoRetry = true
+	}
+}
+
+// WithConsumeOrdered processes events with the same ordering key serially and
+// in order while processing events with different keys concurrently, see
+// WithOrderingKey. Ordering is guaranteed across all the consumers of the sink
+// unless the sink was created with WithSinkNoAck in which case it is only
+// guaranteed within the process.
+func WithConsumeOrdered() Consume {
+	return func(o *ConsumeOptions) {
+		o.Ordered = true
+	}
+}
+
+// ParseConsumeOptions parses the given options and returns the corresponding
+// consume options.
+func ParseConsumeOptions(opts ...Consume) ConsumeOptions {
+	o := defaultConsumeOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// defaultConsumeOptions returns the default options.
+func defaultConsumeOptions() ConsumeOptions {
+	return ConsumeOptions{
+		Concurrency: 1,
+	}
+}
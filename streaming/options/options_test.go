@@ -1,6 +1,7 @@
 package options
 
 import (
+	"compress/gzip"
 	"fmt"
 	"log"
 	"testing"
@@ -20,24 +21,67 @@ func TestStreamOptions(t *testing.T) {
 			name: "default",
 			opts: []Stream{},
 			want: StreamOptions{
-				MaxLen: 1000,
-				Logger: pulse.NoopLogger(),
+				MaxLen:            1000,
+				IdempotencyWindow: 10 * time.Minute,
+				Logger:            pulse.NoopLogger(),
 			},
 		},
 		{
 			name: "maxlen",
 			opts: []Stream{WithStreamMaxLen(10)},
 			want: StreamOptions{
-				MaxLen: 10,
-				Logger: pulse.NoopLogger(),
+				MaxLen:            10,
+				IdempotencyWindow: 10 * time.Minute,
+				Logger:            pulse.NoopLogger(),
+			},
+		},
+		{
+			name: "max age",
+			opts: []Stream{WithStreamMaxAge(time.Hour), WithStreamKeepUnacked()},
+			want: StreamOptions{
+				MaxLen:            1000,
+				MaxAge:            time.Hour,
+				KeepUnacked:       true,
+				IdempotencyWindow: 10 * time.Minute,
+				Logger:            pulse.NoopLogger(),
+			},
+		},
+		{
+			name: "idempotency window",
+			opts: []Stream{WithStreamIdempotencyWindow(time.Minute)},
+			want: StreamOptions{
+				MaxLen:            1000,
+				IdempotencyWindow: time.Minute,
+				Logger:            pulse.NoopLogger(),
+			},
+		},
+		{
+			name: "compression",
+			opts: []Stream{WithStreamCompression(pulse.GzipCompressor(gzip.BestSpeed))},
+			want: StreamOptions{
+				MaxLen:            1000,
+				IdempotencyWindow: 10 * time.Minute,
+				Compressor:        pulse.GzipCompressor(gzip.BestSpeed),
+				Logger:            pulse.NoopLogger(),
+			},
+		},
+		{
+			name: "claim check",
+			opts: []Stream{WithStreamClaimCheck(1024)},
+			want: StreamOptions{
+				MaxLen:              1000,
+				IdempotencyWindow:   10 * time.Minute,
+				ClaimCheckThreshold: 1024,
+				Logger:              pulse.NoopLogger(),
 			},
 		},
 		{
 			name: "custom logger",
 			opts: []Stream{WithStreamLogger(pulse.StdLogger(log.Default()))},
 			want: StreamOptions{
-				MaxLen: 1000,
-				Logger: pulse.StdLogger(log.Default()),
+				MaxLen:            1000,
+				IdempotencyWindow: 10 * time.Minute,
+				Logger:            pulse.StdLogger(log.Default()),
 			},
 		},
 	}
@@ -111,6 +155,29 @@ func TestReaderOptions(t *testing.T) {
 				TopicPattern:  "foo*",
 			},
 		},
+		{
+			name: "durable",
+			opts: []Reader{WithReaderName("reader"), WithReaderCheckpointInterval(time.Second)},
+			want: ReaderOptions{
+				BlockDuration: 5 * time.Second,
+				MaxPolled:     1000,
+				BufferSize:    1000,
+				LastEventID:   "$",
+				Name:          "reader",
+				Checkpoint:    time.Second,
+			},
+		},
+		{
+			name: "headers",
+			opts: []Reader{WithReaderHeader("foo", "bar"), WithReaderHeader("baz", "qux")},
+			want: ReaderOptions{
+				BlockDuration: 5 * time.Second,
+				MaxPolled:     1000,
+				BufferSize:    1000,
+				LastEventID:   "$",
+				Headers:       map[string]string{"foo": "bar", "baz": "qux"},
+			},
+		},
 		{
 			name: "buffer size",
 			opts: []Reader{WithReaderBufferSize(10)},
@@ -208,6 +275,18 @@ func TestSinkOptions(t *testing.T) {
 				TopicPattern:   "foo*",
 			},
 		},
+		{
+			name: "headers",
+			opts: []Sink{WithSinkHeader("foo", "bar"), WithSinkHeader("baz", "qux")},
+			want: SinkOptions{
+				BlockDuration:  5 * time.Second,
+				MaxPolled:      1000,
+				BufferSize:     1000,
+				LastEventID:    "$",
+				AckGracePeriod: 20 * time.Second,
+				Headers:        map[string]string{"foo": "bar", "baz": "qux"},
+			},
+		},
 		{
 			name: "buffer size",
 			opts: []Sink{WithSinkBufferSize(10)},
@@ -264,6 +343,34 @@ func TestSinkOptions(t *testing.T) {
 				AckGracePeriod: 10 * time.Second,
 			},
 		},
+		{
+			name: "max deliveries",
+			opts: []Sink{WithSinkMaxDeliveries(3), WithSinkDeadLetterStream("dlq")},
+			want: SinkOptions{
+				BlockDuration:    5 * time.Second,
+				MaxPolled:        1000,
+				BufferSize:       1000,
+				LastEventID:      "$",
+				AckGracePeriod:   20 * time.Second,
+				MaxDeliveries:    3,
+				DeadLetterStream: "dlq",
+			},
+		},
+		{
+			name: "retry backoff",
+			opts: []Sink{WithSinkRetryBackoff(time.Second, time.Minute, 2, 0.1)},
+			want: SinkOptions{
+				BlockDuration:  5 * time.Second,
+				MaxPolled:      1000,
+				BufferSize:     1000,
+				LastEventID:    "$",
+				AckGracePeriod: 20 * time.Second,
+				RetryInitial:   time.Second,
+				RetryMax:       time.Minute,
+				RetryFactor:    2,
+				RetryJitter:    0.1,
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -277,6 +384,146 @@ func TestSinkOptions(t *testing.T) {
 	}
 }
 
+func TestAddEventOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []AddEvent
+		want AddEventOptions
+	}{
+		{
+			name: "default",
+			opts: []AddEvent{},
+			want: AddEventOptions{},
+		},
+		{
+			name: "topic",
+			opts: []AddEvent{WithTopic("foo")},
+			want: AddEventOptions{Topic: "foo"},
+		},
+		{
+			name: "headers",
+			opts: []AddEvent{WithHeaders(map[string]string{"foo": "bar"}), WithHeaders(map[string]string{"baz": "qux"})},
+			want: AddEventOptions{Headers: map[string]string{"foo": "bar", "baz": "qux"}},
+		},
+		{
+			name: "deliver at",
+			opts: []AddEvent{WithDeliverAt(time.Unix(42, 0))},
+			want: AddEventOptions{DeliverAt: time.Unix(42, 0)},
+		},
+		{
+			name: "ordering key",
+			opts: []AddEvent{WithOrderingKey("foo")},
+			want: AddEventOptions{OrderingKey: "foo"},
+		},
+		{
+			name: "idempotency key",
+			opts: []AddEvent{WithIdempotencyKey("foo")},
+			want: AddEventOptions{IdempotencyKey: "foo"},
+		},
+		{
+			name: "schema version",
+			opts: []AddEvent{WithSchemaVersion(2)},
+			want: AddEventOptions{SchemaVersion: 2},
+		},
+		{
+			name: "only if stream exists",
+			opts: []AddEvent{WithOnlyIfStreamExists()},
+			want: AddEventOptions{OnlyIfStreamExists: true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, ParseAddEventOptions(c.opts...))
+		})
+	}
+
+	o := ParseAddEventOptions(WithDelay(time.Minute))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), o.DeliverAt, time.Second)
+}
+
+func TestConsumeOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Consume
+		want ConsumeOptions
+	}{
+		{
+			name: "default",
+			opts: []Consume{},
+			want: ConsumeOptions{Concurrency: 1},
+		},
+		{
+			name: "concurrency",
+			opts: []Consume{WithConsumeConcurrency(10)},
+			want: ConsumeOptions{Concurrency: 10},
+		},
+		{
+			name: "no auto ack",
+			opts: []Consume{WithConsumeNoAutoAck()},
+			want: ConsumeOptions{Concurrency: 1, NoAutoAck: true},
+		},
+		{
+			name: "retry delay",
+			opts: []Consume{WithConsumeRetryDelay(time.Second)},
+			want: ConsumeOptions{Concurrency: 1, RetryDelay: time.Second},
+		},
+		{
+			name: "no retry",
+			opts: []Consume{WithConsumeNoRetry()},
+			want: ConsumeOptions{Concurrency: 1, NoRetry: true},
+		},
+		{
+			name: "ordered",
+			opts: []Consume{WithConsumeOrdered()},
+			want: ConsumeOptions{Concurrency: 1, Ordered: true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, ParseConsumeOptions(c.opts...))
+		})
+	}
+}
+
+func TestPendingOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Pending
+		want PendingOptions
+	}{
+		{
+			name: "default",
+			opts: []Pending{},
+			want: PendingOptions{Start: "-", Count: 100},
+		},
+		{
+			name: "filters",
+			opts: []Pending{
+				WithPendingStream("stream"),
+				WithPendingConsumer("consumer"),
+				WithPendingMinIdle(time.Second),
+				WithPendingStartAt("1-0"),
+				WithPendingCount(10),
+			},
+			want: PendingOptions{
+				Stream:   "stream",
+				Consumer: "consumer",
+				MinIdle:  time.Second,
+				Start:    "1-0",
+				Count:    10,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, ParsePendingOptions(c.opts...))
+		})
+	}
+}
+
 func TestAddStreamOptions(t *testing.T) {
 	date := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
 	cases := []struct {
@@ -317,3 +564,34 @@ func TestAddStreamOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestSubscribeOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Subscribe
+		want SubscribeOptions
+	}{
+		{
+			name: "default",
+			opts: []Subscribe{},
+			want: SubscribeOptions{Overflow: OverflowBlock},
+		},
+		{
+			name: "overflow",
+			opts: []Subscribe{WithSubscribeOverflow(OverflowDropOldest)},
+			want: SubscribeOptions{Overflow: OverflowDropOldest},
+		},
+		{
+			name: "buffer size",
+			opts: []Subscribe{WithSubscribeBufferSize(10)},
+			want: SubscribeOptions{BufferSize: 10},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, ParseSubscribeOptions(c.opts...))
+		})
+	}
+	assert.Equal(t, "drop-newest", OverflowDropNewest.String())
+}
@@ -0,0 +1,73 @@
+package options
+
+import "time"
+
+type (
+	// Pending is an option for listing the pending events of a sink.
+	Pending func(*PendingOptions)
+
+	PendingOptions struct {
+		Stream   string
+		Consumer string
+		MinIdle  time.Duration
+		Start    string
+		Count    int64
+	}
+)
+
+// WithPendingStream only lists the pending events of the stream with the given
+// name. By default the pending events of all the sink streams are listed.
+func WithPendingStream(name string) Pending {
+	return func(o *PendingOptions) {
+		o.Stream = name
+	}
+}
+
+// WithPendingConsumer only lists the events pending for the consumer with the
+// given name.
+func WithPendingConsumer(name string) Pending {
+	return func(o *PendingOptions) {
+		o.Consumer = name
+	}
+}
+
+// WithPendingMinIdle only lists the events that were delivered at least d ago.
+func WithPendingMinIdle(d time.Duration) Pending {
+	return func(o *PendingOptions) {
+		o.MinIdle = d
+	}
+}
+
+// WithPendingStartAt lists the pending events starting with the event with
+// the given ID. By default events are listed starting with the oldest.
+func WithPendingStartAt(id string) Pending {
+	return func(o *PendingOptions) {
+		o.Start = id
+	}
+}
+
+// WithPendingCount sets the maximum number of events listed per stream. The
+// default is 100.
+func WithPendingCount(n int64) Pending {
+	return func(o *PendingOptions) {
+		o.Count = n
+	}
+}
+
+// ParsePendingOptions parses the given options and returns the corresponding
+// pending options.
+func ParsePendingOptions(opts ...Pending) PendingOptions {
+	o := defaultPendingOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// defaultPendingOptions returns the default options.
+func defaultPendingOptions() PendingOptions {
+	return PendingOptions{
+		Start: "-",
+		Count: 100,
+	}
+}
@@ -14,8 +14,11 @@ type (
 		MaxPolled     int64
 		Topic         string
 		TopicPattern  string
+		Headers       map[string]string
 		BufferSize    int
 		LastEventID   string
+		Name          string
+		Checkpoint    time.Duration
 	}
 )
 
@@ -51,6 +54,19 @@ func WithReaderTopicPattern(pattern string) Reader {
 	}
 }
 
+// WithReaderHeader only reads events whose header with the given key has the
+// given value. WithReaderHeader may be used multiple times, events must then
+// match all the headers. Header filters are combined with the topic filter if
+// any.
+func WithReaderHeader(key, value string) Reader {
+	return func(o *ReaderOptions) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+		o.Headers[key] = value
+	}
+}
+
 // WithReaderBufferSize sets the reader channel buffer size.  The default buffer
 // size is 1000. If the buffer is full the reader blocks until the buffer has
 // space available.
@@ -95,6 +111,31 @@ func WithReaderStartAt(startAt time.Time) Reader {
 	}
 }
 
+// WithReaderName makes the reader durable: the ID of the last processed event
+// of each stream is checkpointed in Redis under the given name, see
+// Reader.Commit and WithReaderCheckpointInterval. A durable reader resumes
+// right after the checkpointed events when it is created again with the same
+// name. The start position set with the other options only applies to streams
+// without a checkpoint.
+func WithReaderName(name string) Reader {
+	return func(o *ReaderOptions) {
+		o.Name = name
+	}
+}
+
+// WithReaderCheckpointInterval sets the interval at which a durable reader
+// checkpoints the ID of the last event consumed by all its subscribers. An
+// event is considered consumed once the subscriber has received the next event
+// from its channel so that events being processed when the process crashes
+// are delivered again. Checkpoints are also written when the reader is closed.
+// By default checkpoints are only written by Reader.Commit. This option has no
+// effect on readers created without WithReaderName.
+func WithReaderCheckpointInterval(d time.Duration) Reader {
+	return func(o *ReaderOptions) {
+		o.Checkpoint = d
+	}
+}
+
 // ParseReaderOptions parses the given options and returns the corresponding
 // reader options.
 func ParseReaderOptions(opts ...Reader) ReaderOptions {
@@ -10,14 +10,21 @@ type (
 	Sink func(*SinkOptions)
 
 	SinkOptions struct {
-		BlockDuration  time.Duration
-		MaxPolled      int64
-		Topic          string
-		TopicPattern   string
-		BufferSize     int
-		LastEventID    string
-		NoAck          bool
-		AckGracePeriod time.Duration
+		BlockDuration    time.Duration
+		MaxPolled        int64
+		Topic            string
+		TopicPattern     string
+		Headers          map[string]string
+		BufferSize       int
+		LastEventID      string
+		NoAck            bool
+		AckGracePeriod   time.Duration
+		MaxDeliveries    int64
+		DeadLetterStream string
+		RetryInitial     time.Duration
+		RetryMax         time.Duration
+		RetryFactor      float64
+		RetryJitter      float64
 	}
 )
 
@@ -53,6 +60,19 @@ func WithSinkTopicPattern(pattern string) Sink {
 	}
 }
 
+// WithSinkHeader only consumes events whose header with the given key has the
+// given value. WithSinkHeader may be used multiple times, events must then
+// match all the headers. Header filters are combined with the topic filter if
+// any.
+func WithSinkHeader(key, value string) Sink {
+	return func(o *SinkOptions) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+		o.Headers[key] = value
+	}
+}
+
 // WithSinkBufferSize sets the sink channel buffer size.  The default buffer
 // size is 1000. If the buffer is full the sink blocks until the buffer has
 // space available.
@@ -114,6 +134,42 @@ func WithSinkAckGracePeriod(d time.Duration) Sink {
 	}
 }
 
+// WithSinkMaxDeliveries sets the maximum number of times an event is delivered
+// to the sink consumers. Events that are still not acknowledged after n
+// deliveries are moved to the sink dead-letter stream instead of being
+// redelivered. The default is 0 which means events are redelivered until they
+// are acknowledged.
+func WithSinkMaxDeliveries(n int64) Sink {
+	return func(o *SinkOptions) {
+		o.MaxDeliveries = n
+	}
+}
+
+// WithSinkDeadLetterStream sets the name of the stream that receives the events
+// that exceed the maximum number of deliveries, see WithSinkMaxDeliveries. The
+// default dead-letter stream name is the sink name followed by ":dlq".
+func WithSinkDeadLetterStream(name string) Sink {
+	return func(o *SinkOptions) {
+		o.DeadLetterStream = name
+	}
+}
+
+// WithSinkRetryBackoff sets the exponential backoff policy used to compute the
+// redelivery delay of events negatively acknowledged without an explicit delay,
+// see Sink.Nack. The first redelivery happens after initial, the delay is then
+// multiplied by factor for each subsequent delivery up to max (no limit if max
+// is 0). jitter is the fraction of the delay that is randomly added or
+// subtracted, between 0 and 1. By default events negatively acknowledged
+// without an explicit delay are redelivered right away.
+func WithSinkRetryBackoff(initial, max time.Duration, factor, jitter float64) Sink {
+	return func(o *SinkOptions) {
+		o.RetryInitial = initial
+		o.RetryMax = max
+		o.RetryFactor = factor
+		o.RetryJitter = jitter
+	}
+}
+
 // ParseSinkOptions parses the options and returns the sink options.
 func ParseSinkOptions(opts ...Sink) SinkOptions {
 	o := defaultSinkOptions()
@@ -1,6 +1,9 @@
 package options
 
 import (
+	"context"
+	"time"
+
 	"goa.design/pulse/pulse"
 )
 
@@ -8,9 +11,27 @@ type (
 	// Stream is a stream creation option.
 	Stream func(*StreamOptions)
 
+	// BlobStore stores the payloads offloaded from stream events, see
+	// WithStreamClaimCheck.
+	BlobStore interface {
+		// Put stores data under key.
+		Put(ctx context.Context, key string, data []byte) error
+		// Get returns the data stored under key.
+		Get(ctx context.Context, key string) ([]byte, error)
+		// Delete deletes the data stored under the given keys. Missing
+		// keys are ignored.
+		Delete(ctx context.Context, keys ...string) error
+	}
+
 	StreamOptions struct {
-		MaxLen int
-		Logger pulse.Logger
+		MaxLen              int
+		MaxAge              time.Duration
+		KeepUnacked         bool
+		IdempotencyWindow   time.Duration
+		Compressor          pulse.Compressor
+		ClaimCheckThreshold int
+		BlobStore           BlobStore
+		Logger              pulse.Logger
 	}
 )
 
@@ -21,6 +42,71 @@ func WithStreamMaxLen(len int) Stream {
 	}
 }
 
+// WithStreamMaxAge sets the maximum age of the events stored by the stream.
+// Older events are trimmed when events are added and periodically by the
+// stream sinks and readers. Trimming is approximate: events may be retained a
+// little longer than d.
+func WithStreamMaxAge(d time.Duration) Stream {
+	return func(o *StreamOptions) {
+		o.MaxAge = d
+	}
+}
+
+// WithStreamKeepUnacked prevents the stream from trimming events that have not
+// been delivered to or acknowledged by all the stream sinks, even if they
+// exceed the maximum length or age of the stream. Trimming is then done
+// periodically by the stream sinks and readers instead of when events are
+// added, and thus only while at least one of them is running. As a safety net
+// adding events still caps the stream to 10 times its maximum length, events
+// beyond that cap are trimmed even if not acknowledged.
+func WithStreamKeepUnacked() Stream {
+	return func(o *StreamOptions) {
+		o.KeepUnacked = true
+	}
+}
+
+// WithStreamIdempotencyWindow sets the duration during which the idempotency
+// keys of the events added to the stream are remembered, see
+// WithIdempotencyKey. The default is 10 minutes.
+func WithStreamIdempotencyWindow(d time.Duration) Stream {
+	return func(o *StreamOptions) {
+		o.IdempotencyWindow = d
+	}
+}
+
+// WithStreamCompression compresses the payloads of the events added to the
+// stream with c, for example pulse.GzipCompressor(gzip.BestSpeed). Each event
+// records the name of the compressor so that sinks and readers decompress
+// payloads automatically, see pulse.RegisterCompressor.
+func WithStreamCompression(c pulse.Compressor) Stream {
+	return func(o *StreamOptions) {
+		o.Compressor = c
+	}
+}
+
+// WithStreamClaimCheck stores the payloads of the events added to the stream
+// that are larger than threshold bytes (after compression if any) separately
+// and only stores a reference in the stream. Payloads are stored in Redis
+// unless WithStreamBlobStore is used. Sinks and readers fetch the payloads
+// transparently. Stored payloads are deleted periodically by the stream sinks
+// and readers once the corresponding events are trimmed from the stream. The
+// payloads of scheduled events are offloaded when they are scheduled and kept
+// until the events are added to the stream and trimmed.
+func WithStreamClaimCheck(threshold int) Stream {
+	return func(o *StreamOptions) {
+		o.ClaimCheckThreshold = threshold
+	}
+}
+
+// WithStreamBlobStore sets the store used to save the payloads offloaded from
+// the stream, see WithStreamClaimCheck. The sinks and readers of the stream
+// must be created from a stream that uses the same store.
+func WithStreamBlobStore(store BlobStore) Stream {
+	return func(o *StreamOptions) {
+		o.BlobStore = store
+	}
+}
+
 // WithStreamLogger sets the logger used by the stream.
 func WithStreamLogger(logger pulse.Logger) Stream {
 	return func(o *StreamOptions) {
@@ -41,7 +127,8 @@ func ParseStreamOptions(opts ...Stream) StreamOptions {
 // defaultStreamOptions returns the default options.
 func defaultStreamOptions() StreamOptions {
 	return StreamOptions{
-		MaxLen: 1000,
-		Logger: pulse.NoopLogger(),
+		MaxLen:            1000,
+		IdempotencyWindow: 10 * time.Minute,
+		Logger:            pulse.NoopLogger(),
 	}
 }
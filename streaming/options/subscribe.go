@@ -0,0 +1,81 @@
+package options
+
+type (
+	// Subscribe is an option for subscribing to the events of a sink or a
+	// reader.
+	Subscribe func(*SubscribeOptions)
+
+	SubscribeOptions struct {
+		Overflow   OverflowPolicy
+		BufferSize int
+	}
+
+	// OverflowPolicy defines what happens to events delivered to a
+	// subscription whose channel buffer is full.
+	OverflowPolicy int
+)
+
+const (
+	// OverflowBlock blocks until the subscriber receives the event. A slow
+	// subscriber delays the delivery of events to all the subscribers of
+	// the sink or reader. This is the default.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the event being delivered.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest event buffered in the channel to
+	// make room for the event being delivered. It behaves like
+	// OverflowDropNewest if the channel is unbuffered.
+	OverflowDropOldest
+	// OverflowFail closes the subscription channel, the subscriber stops
+	// receiving events.
+	OverflowFail
+)
+
+// WithSubscribeOverflow sets the policy applied when the subscription channel
+// buffer is full. The default is OverflowBlock. Events dropped by a sink
+// subscription are not acknowledged and are thus redelivered once the sink
+// ack grace period elapses.
+func WithSubscribeOverflow(policy OverflowPolicy) Subscribe {
+	return func(o *SubscribeOptions) {
+		o.Overflow = policy
+	}
+}
+
+// WithSubscribeBufferSize sets the size of the subscription channel buffer.
+// The default is the buffer size of the sink or reader, see
+// WithSinkBufferSize and WithReaderBufferSize.
+func WithSubscribeBufferSize(size int) Subscribe {
+	return func(o *SubscribeOptions) {
+		o.BufferSize = size
+	}
+}
+
+// ParseSubscribeOptions parses the given options and returns the corresponding
+// SubscribeOptions.
+func ParseSubscribeOptions(opts ...Subscribe) SubscribeOptions {
+	o := defaultSubscribeOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// defaultSubscribeOptions returns the default options.
+func defaultSubscribeOptions() SubscribeOptions {
+	return SubscribeOptions{Overflow: OverflowBlock}
+}
+
+// String returns the name of the policy.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowFail:
+		return "fail"
+	}
+	return "unknown"
+}
@@ -0,0 +1,208 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+)
+
+// orderingCheckPeriod is the period at which deferred events are checked
+// again.
+var orderingCheckPeriod = 100 * time.Millisecond
+
+// consumeOrdered processes the events received on c until ctx is canceled or
+// c is closed. Events are dispatched to workers by ordering key so that events
+// with the same key are processed serially. Workers defer events that have
+// older events with the same key still pending in the sink, for example
+// because they are being processed by another consumer. consumeOrdered
+// returns the events that were received but not processed.
+func (s *Sink) consumeOrdered(ctx, hctx context.Context, handler Handler, c <-chan *Event, o options.ConsumeOptions) []*Event {
+	queues := make([]chan *Event, o.Concurrency)
+	for i := range queues {
+		queues[i] = make(chan *Event)
+	}
+	var (
+		wg          sync.WaitGroup
+		lock        sync.Mutex
+		unprocessed []*Event
+	)
+	wg.Add(o.Concurrency)
+	for _, queue := range queues {
+		pulse.Go(ctx, func() {
+			defer wg.Done()
+			deferred := s.orderedWorker(ctx, hctx, handler, queue, o)
+			lock.Lock()
+			unprocessed = append(unprocessed, deferred...)
+			lock.Unlock()
+		})
+	}
+dispatch:
+	for {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case ev, ok := <-c:
+			if !ok {
+				break dispatch
+			}
+			select {
+			case queues[workerIndex(ev, len(queues))] <- ev:
+			case <-ctx.Done():
+				unprocessed = append(unprocessed, ev)
+				break dispatch
+			}
+		}
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return unprocessed
+}
+
+// orderedWorker processes the events received on queue serially until ctx is
+// canceled or queue is closed. It returns the events that were deferred and
+// not processed.
+func (s *Sink) orderedWorker(ctx, hctx context.Context, handler Handler, queue <-chan *Event, o options.ConsumeOptions) []*Event {
+	ticker := time.NewTicker(orderingCheckPeriod)
+	defer ticker.Stop()
+
+	var (
+		deferred  []*Event
+		lastReset = time.Now()
+	)
+	process := func(ev *Event) {
+		for _, d := range deferred {
+			if d.StreamName != ev.StreamName {
+				continue
+			}
+			if d.ID == ev.ID {
+				return // Redelivered while deferred
+			}
+			if ev.OrderingKey != "" && d.OrderingKey == ev.OrderingKey && compareEventIDs(d.ID, ev.ID) < 0 {
+				// An older event with the same key is deferred. Newer
+				// deferred events do not block ev: ev may be their
+				// predecessor claimed from another consumer.
+				deferred = append(deferred, ev)
+				return
+			}
+		}
+		next, err := s.isNextForKey(hctx, ev)
+		if err != nil {
+			s.logger.Error(fmt.Errorf("failed to check event order: %w", err), "event", ev.ID, "stream", ev.StreamName)
+		}
+		if !next {
+			s.logger.Debug("deferred", "event", ev.ID, "stream", ev.StreamName, "key", ev.OrderingKey)
+			deferred = append(deferred, ev)
+			return
+		}
+		s.handle(hctx, handler, ev, o)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return deferred
+		case ev, ok := <-queue:
+			if !ok {
+				return deferred
+			}
+			process(ev)
+		case <-ticker.C:
+			evs := deferred
+			if len(evs) > 0 && time.Since(lastReset) > s.ackGracePeriod/2 {
+				evs = s.keepDeferred(hctx, evs)
+				lastReset = time.Now()
+			}
+			deferred = nil
+			// Process older events first so that they unblock the
+			// newer events with the same key.
+			sort.SliceStable(evs, func(i, j int) bool { return compareEventIDs(evs[i].ID, evs[j].ID) < 0 })
+			for _, ev := range evs {
+				if ctx.Err() != nil {
+					deferred = append(deferred, ev)
+					continue
+				}
+				process(ev)
+			}
+		}
+	}
+}
+
+// isNextForKey returns true if the event has no ordering key or if the
+// previous event added with the same ordering key is no longer pending in the
+// sink. Events with the same key are processed in order so the previous event
+// no longer being pending means that all the older events with the same key
+// were processed, filtered out (see loadEvents) or dead-lettered.
+func (s *Sink) isNextForKey(ctx context.Context, ev *Event) (bool, error) {
+	if ev.OrderingKey == "" || ev.prevKeyedID == "" || s.noAck {
+		return true, nil
+	}
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: ev.streamKey,
+		Group:  s.Name,
+		Start:  ev.prevKeyedID,
+		End:    ev.prevKeyedID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return false, err
+	}
+	return len(pending) == 0, nil
+}
+
+// keepDeferred resets the idle time of the given deferred events so that they
+// do not get claimed by other consumers or dead-lettered while waiting for the
+// older events with the same ordering key to be processed. It returns the
+// events that are still pending, the others were acknowledged or
+// dead-lettered elsewhere.
+func (s *Sink) keepDeferred(ctx context.Context, evs []*Event) []*Event {
+	ids := make(map[string][]string)
+	for _, ev := range evs {
+		ids[ev.streamKey] = append(ids[ev.streamKey], ev.ID)
+	}
+	pending := make(map[string]struct{}, len(evs))
+	for key, keyIDs := range ids {
+		// Claiming with JUSTID resets the idle time without incrementing
+		// the delivery count.
+		claimed, err := s.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   key,
+			Group:    s.Name,
+			Consumer: s.consumer,
+			Messages: keyIDs,
+		}).Result()
+		if err != nil {
+			s.logger.Error(fmt.Errorf("failed to reset idle time of deferred events: %w", err), "stream", key)
+			claimed = keyIDs
+		}
+		for _, id := range claimed {
+			pending[key+":"+id] = struct{}{}
+		}
+	}
+	kept := evs[:0]
+	for _, ev := range evs {
+		if _, ok := pending[ev.streamKey+":"+ev.ID]; ok {
+			kept = append(kept, ev)
+		}
+	}
+	return kept
+}
+
+// workerIndex returns the index of the worker that processes the event.
+// Events with the same ordering key are processed by the same worker.
+func workerIndex(ev *Event, n int) int {
+	key := ev.OrderingKey
+	if key == "" {
+		key = ev.ID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key)) // nolint: errcheck
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,164 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestConsumeOrdered(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+
+	// Two consumers of the same sink
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkMaxPolled(1))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	sink2, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkMaxPolled(1))
+	require.NoError(t, err)
+	defer sink2.Close(ctx)
+
+	const numKeys, numEvents = 3, 10
+	var lock sync.Mutex
+	processed := make(map[string][]int)
+	var count int
+	handler := func(_ context.Context, ev *Event) error {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		i, err := strconv.Atoi(string(ev.Payload))
+		assert.NoError(t, err)
+		lock.Lock()
+		defer lock.Unlock()
+		processed[ev.OrderingKey] = append(processed[ev.OrderingKey], i)
+		count++
+		return nil
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts := []options.Consume{options.WithConsumeOrdered(), options.WithConsumeConcurrency(2)}
+	go sink.Consume(cctx, handler, opts...)  // nolint: errcheck
+	go sink2.Consume(cctx, handler, opts...) // nolint: errcheck
+
+	for i := 0; i < numEvents; i++ {
+		for k := 0; k < numKeys; k++ {
+			_, err := s.Add(ctx, "event", []byte(strconv.Itoa(i)), options.WithOrderingKey(fmt.Sprintf("key%d", k)))
+			require.NoError(t, err)
+		}
+	}
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return count == numKeys*numEvents
+	}, 5*time.Second, delay)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for k := 0; k < numKeys; k++ {
+		key := fmt.Sprintf("key%d", k)
+		for i, n := range processed[key] {
+			assert.Equal(t, i, n, "key %s processed out of order: %v", key, processed[key])
+		}
+	}
+}
+
+func TestConsumeOrderedFiltered(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkTopic("kept"))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+
+	processed := make(chan string, 1)
+	handler := func(_ context.Context, ev *Event) error {
+		processed <- string(ev.Payload)
+		return nil
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go sink.Consume(cctx, handler, options.WithConsumeOrdered()) // nolint: errcheck
+
+	// Events whose predecessor was filtered out are not deferred forever
+	_, err = s.Add(ctx, "event", []byte("filtered"), options.WithOrderingKey("key"), options.WithTopic("other"))
+	require.NoError(t, err)
+	_, err = s.Add(ctx, "event", []byte("kept"), options.WithOrderingKey("key"), options.WithTopic("kept"))
+	require.NoError(t, err)
+	select {
+	case payload := <-processed:
+		assert.Equal(t, "kept", payload)
+	case <-time.After(max):
+		t.Fatal("timeout waiting for event")
+	}
+	assert.Eventually(t, func() bool {
+		pending, err := rdb.XPending(ctx, s.key, sink.Name).Result()
+		return err == nil && pending.Count == 0
+	}, max, delay)
+}
+
+func TestPrevKeyedID(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+
+	id1, err := s.Add(ctx, "event", []byte("1"), options.WithOrderingKey("a"))
+	require.NoError(t, err)
+	id2, err := s.Add(ctx, "event", []byte("2"), options.WithOrderingKey("b"))
+	require.NoError(t, err)
+	id3, err := s.Add(ctx, "event", []byte("3"), options.WithOrderingKey("a"))
+	require.NoError(t, err)
+	_, err = s.Add(ctx, "event", []byte("4"))
+	require.NoError(t, err)
+
+	msgs, err := rdb.XRange(ctx, s.key, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 4)
+	assert.Equal(t, id1, msgs[0].ID)
+	assert.NotContains(t, msgs[0].Values, prevKeyedKey)
+	assert.Equal(t, id2, msgs[1].ID)
+	assert.NotContains(t, msgs[1].Values, prevKeyedKey)
+	assert.Equal(t, id3, msgs[2].ID)
+	assert.Equal(t, id1, msgs[2].Values[prevKeyedKey])
+	assert.NotContains(t, msgs[3].Values, prevKeyedKey)
+}
+
+func TestWorkerIndex(t *testing.T) {
+	ev := &Event{ID: "1-0", OrderingKey: "key"}
+	ev2 := &Event{ID: "2-0", OrderingKey: "key"}
+	assert.Equal(t, workerIndex(ev, 10), workerIndex(ev2, 10))
+	for i := 0; i < 100; i++ {
+		idx := workerIndex(&Event{ID: fmt.Sprintf("%d-0", i)}, 10)
+		assert.GreaterOrEqual(t, idx, 0)
+		assert.Less(t, idx, 10)
+	}
+}
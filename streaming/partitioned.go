@@ -0,0 +1,449 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/rmap"
+	"goa.design/pulse/streaming/options"
+)
+
+// partitionCheckPeriod is the period at which partitioned sink consumers
+// record their keep-alive and rebalance partitions.
+var partitionCheckPeriod = time.Second
+
+type (
+	// PartitionedStream spreads events over a fixed number of underlying
+	// streams (partitions) so that writes can scale beyond a single Redis
+	// stream key. Events are assigned to partitions by hashing their
+	// ordering key (see options.WithOrderingKey) so that events with the
+	// same key always go to the same partition.
+	PartitionedStream struct {
+		// Name of the stream.
+		Name string
+		// Partitions are the underlying streams.
+		Partitions []*Stream
+		// logger is the logger used by the stream.
+		logger pulse.Logger
+		// rootLogger is the prefix-free logger used to create sink loggers.
+		rootLogger pulse.Logger
+		// rdb is the redis connection.
+		rdb redis.UniversalClient
+	}
+
+	// PartitionedSink is a sink that consumes events from a partitioned
+	// stream. The partitions are spread across all the consumers of the
+	// sink (i.e. all the partitioned sinks with the same name) so that each
+	// partition is consumed by a single consumer at a time. Partitions are
+	// rebalanced automatically as consumers join or leave.
+	PartitionedSink struct {
+		// Name is the sink name.
+		Name string
+		// stream is the partitioned stream.
+		stream *PartitionedStream
+		// opts are the options used to create the partition sinks.
+		opts []options.Sink
+		// member is the consumer ID used to compute partition ownership.
+		member string
+		// bufferSize is the sink channel buffer size.
+		bufferSize int
+		// partitionsMap is the replicated map used to track the sink
+		// members and partition leases.
+		partitionsMap *rmap.Map
+		// lock protects the fields below.
+		lock sync.Mutex
+		// sinks are the sinks of the owned partitions indexed by
+		// partition.
+		sinks map[int]*Sink
+		// leases are the leases of the owned partitions indexed by
+		// partition.
+		leases map[int]*rmap.Lock
+		// subs are the sink subscriptions.
+		subs []*subscription
+		// subsLock protects subs.
+		subsLock sync.RWMutex
+		// index indexes subs so that Unsubscribe does not wait for
+		// subsLock.
+		index subscriptionIndex
+		// donechan is the sink done channel.
+		donechan chan struct{}
+		// wait is the sink cleanup wait group.
+		wait sync.WaitGroup
+		// closing is true if Close was called.
+		closing bool
+		// logger is the logger used by the sink.
+		logger pulse.Logger
+	}
+)
+
+// NewPartitionedStream returns the partitioned stream with the given name and
+// number of partitions. All stream instances with the same name must use the
+// same number of partitions. When using Redis Cluster the name should not
+// include a hash tag so that the partitions are spread across the cluster
+// nodes.
+func NewPartitionedStream(name string, partitions int, rdb redis.UniversalClient, opts ...options.Stream) (*PartitionedStream, error) {
+	if partitions < 1 {
+		return nil, fmt.Errorf("pulse stream: invalid number of partitions %d for %q", partitions, name)
+	}
+	streams := make([]*Stream, partitions)
+	for i := range streams {
+		s, err := NewStream(partitionName(name, i), rdb, opts...)
+		if err != nil {
+			return nil, err
+		}
+		streams[i] = s
+	}
+	o := options.ParseStreamOptions(opts...)
+	var logger pulse.Logger
+	if o.Logger != nil {
+		logger = o.Logger.WithPrefix("stream", name)
+	} else {
+		logger = pulse.NoopLogger()
+	}
+	return &PartitionedStream{
+		Name:       name,
+		Partitions: streams,
+		logger:     logger,
+		rootLogger: o.Logger,
+		rdb:        rdb,
+	}, nil
+}
+
+// Add appends an event to the partition that corresponds to the event
+// ordering key and returns its ID. Events without an ordering key are added
+// to a random partition. The ID is unique within the partition.
+func (ps *PartitionedStream) Add(ctx context.Context, name string, payload []byte, opts ...options.AddEvent) (string, error) {
+	o := options.ParseAddEventOptions(opts...)
+	var stream *Stream
+	if o.OrderingKey != "" {
+		stream = ps.Partition(o.OrderingKey)
+	} else {
+		stream = ps.Partitions[rand.Intn(len(ps.Partitions))]
+	}
+	return stream.Add(ctx, name, payload, opts...)
+}
+
+// Partition returns the partition that stores the events with the given
+// ordering key.
+func (ps *PartitionedStream) Partition(key string) *Stream {
+	h := fnv.New32a()
+	h.Write([]byte(key)) // nolint: errcheck
+	return ps.Partitions[h.Sum32()%uint32(len(ps.Partitions))]
+}
+
+// NewSink creates a new partitioned sink with the given name. The options are
+// used to create the sinks of the partitions owned by the consumer.
+func (ps *PartitionedStream) NewSink(ctx context.Context, name string, opts ...options.Sink) (*PartitionedSink, error) {
+	logger := ps.rootLogger.WithPrefix("sink", name)
+	pm, err := rmap.Join(ctx, partitionsMapName(ps.Name, name), ps.rdb, rmap.WithLogger(logger))
+	if err != nil {
+		err = fmt.Errorf("failed to join replicated map for partitioned sink %s: %w", name, err)
+		ps.logger.Error(err, "sink", name)
+		return nil, err
+	}
+	member := ulid.Make().String()
+	s := &PartitionedSink{
+		Name:          name,
+		stream:        ps,
+		opts:          opts,
+		member:        member,
+		bufferSize:    options.ParseSinkOptions(opts...).BufferSize,
+		partitionsMap: pm,
+		sinks:         make(map[int]*Sink),
+		leases:        make(map[int]*rmap.Lock),
+		donechan:      make(chan struct{}),
+		logger:        logger.WithPrefix("member", member),
+	}
+	if _, err := pm.SetWithTTL(ctx, memberKey(member), strconv.FormatInt(time.Now().UnixNano(), 10), 3*partitionCheckPeriod); err != nil {
+		pm.Close()
+		err = fmt.Errorf("failed to register partitioned sink %s member: %w", name, err)
+		ps.logger.Error(err, "sink", name)
+		return nil, err
+	}
+	s.wait.Add(1)
+	pulse.Go(ctx, s.rebalanceLoop)
+	s.logger.Info("created", "partitions", len(ps.Partitions))
+	return s, nil
+}
+
+// Destroy deletes all the partitions and their events.
+func (ps *PartitionedStream) Destroy(ctx context.Context) error {
+	for _, s := range ps.Partitions {
+		if err := s.Destroy(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel that receives events from the partitions owned
+// by the sink, see Sink.Subscribe.
+func (s *PartitionedSink) Subscribe(opts ...options.Subscribe) <-chan *Event {
+	sub := newSubscription(s.bufferSize, opts...)
+	s.subsLock.Lock()
+	defer s.subsLock.Unlock()
+	s.subs = append(s.subs, sub)
+	s.index.add(sub)
+	return sub.c
+}
+
+// Unsubscribe removes the channel from the sink and closes it.
+func (s *PartitionedSink) Unsubscribe(c <-chan *Event) {
+	s.index.cancel(c) // Interrupt any send blocked on c
+	s.subsLock.Lock()
+	defer s.subsLock.Unlock()
+	if i := findSubscription(s.subs, c); i >= 0 {
+		s.subs[i].close()
+		s.subs = append(s.subs[:i], s.subs[i+1:]...)
+	}
+}
+
+// Dropped returns the number of events dropped by the subscription with
+// channel c because its buffer was full, see WithSubscribeOverflow.
+func (s *PartitionedSink) Dropped(c <-chan *Event) int64 {
+	s.subsLock.RLock()
+	defer s.subsLock.RUnlock()
+	return dropped(s.subs, c)
+}
+
+// Ack acknowledges the event.
+func (s *PartitionedSink) Ack(ctx context.Context, e *Event) error {
+	if e.sink == nil {
+		return fmt.Errorf("cannot ack event %s: event was not read from a sink", e.ID)
+	}
+	return e.sink.Ack(ctx, e)
+}
+
+// Partitions returns the indices of the partitions currently owned by the
+// sink in ascending order.
+func (s *PartitionedSink) Partitions() []int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([]int, 0, len(s.sinks))
+	for p := range s.sinks {
+		res = append(res, p)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// Close stops consuming events, releases the owned partitions so they can be
+// picked up by the other consumers and closes the sink channels. It is safe
+// to call Close multiple times.
+func (s *PartitionedSink) Close(ctx context.Context) {
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		return
+	}
+	s.closing = true
+	close(s.donechan)
+	s.lock.Unlock()
+	s.wait.Wait()
+
+	s.lock.Lock()
+	var released []func(context.Context)
+	for p := range s.sinks {
+		released = append(released, s.detachPartition(p))
+	}
+	s.lock.Unlock()
+	for _, release := range released {
+		release(ctx)
+	}
+	if _, err := s.partitionsMap.Delete(ctx, memberKey(s.member)); err != nil {
+		s.logger.Error(fmt.Errorf("failed to delete member: %w", err))
+	}
+	s.partitionsMap.Close()
+	s.subsLock.Lock()
+	for _, sub := range s.subs {
+		sub.close()
+	}
+	s.subs = nil
+	s.index.cancelAll()
+	s.subsLock.Unlock()
+	s.logger.Info("closed")
+}
+
+// rebalanceLoop records the member keep-alive, refreshes the partition leases
+// and rebalances the partitions periodically. It also rebalances the
+// partitions each time a member joins or leaves, keep-alive updates of
+// existing members do not trigger a rebalance.
+func (s *PartitionedSink) rebalanceLoop() {
+	defer s.wait.Done()
+	defer s.logger.Debug("rebalanceLoop: exiting")
+	ticker := time.NewTicker(partitionCheckPeriod)
+	defer ticker.Stop()
+	changes := s.partitionsMap.WatchPrefix(memberKeyPrefix)
+	defer s.partitionsMap.UnsubscribeChanges(changes)
+
+	ctx := context.Background()
+	s.rebalance(ctx, false)
+	for {
+		select {
+		case <-ticker.C:
+			now := strconv.FormatInt(time.Now().UnixNano(), 10)
+			if _, err := s.partitionsMap.SetWithTTL(ctx, memberKey(s.member), now, 3*partitionCheckPeriod); err != nil {
+				s.logger.Error(fmt.Errorf("failed to update member keep-alive: %w", err))
+			}
+			s.rebalance(ctx, true)
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if isMembershipChange(change) {
+				s.rebalance(ctx, false)
+			}
+		case <-s.donechan:
+			return
+		}
+	}
+}
+
+// isMembershipChange returns true if the change adds or removes a member as
+// opposed to updating the keep-alive of an existing member.
+func isMembershipChange(change *rmap.Change) bool {
+	return change.Kind != rmap.EventChange || !change.Existed
+}
+
+// rebalance starts consuming the partitions assigned to the member and stops
+// consuming the partitions assigned to other members. Partitions are assigned
+// round-robin to the members sorted by ID. Partition leases guarantee that a
+// partition is consumed by a single member even while members disagree on
+// the assignment. rebalance also refreshes the leases of the partitions that
+// remain assigned to the member if refresh is true.
+func (s *PartitionedSink) rebalance(ctx context.Context, refresh bool) {
+	var members []string
+	for _, key := range s.partitionsMap.Keys() {
+		if strings.HasPrefix(key, memberKeyPrefix) {
+			members = append(members, key[len(memberKeyPrefix):])
+		}
+	}
+	sort.Strings(members)
+	index := sort.SearchStrings(members, s.member)
+	if index == len(members) || members[index] != s.member {
+		// Keep-alive expired, wait for next tick to register again.
+		members = nil
+	}
+
+	var released []func(context.Context)
+	defer func() {
+		for _, release := range released {
+			release(ctx)
+		}
+	}()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closing {
+		return
+	}
+	for p := range s.stream.Partitions {
+		owned := len(members) > 0 && p%len(members) == index
+		_, held := s.sinks[p]
+		switch {
+		case owned && !held:
+			s.acquirePartition(ctx, p)
+		case !owned && held:
+			released = append(released, s.detachPartition(p))
+		case held && refresh:
+			if err := s.leases[p].Refresh(ctx); err != nil {
+				s.logger.Error(fmt.Errorf("failed to refresh partition lease: %w", err), "partition", p)
+				released = append(released, s.detachPartition(p))
+			}
+		}
+	}
+}
+
+// acquirePartition starts consuming the given partition if its lease can be
+// acquired. s.lock must be held.
+func (s *PartitionedSink) acquirePartition(ctx context.Context, p int) {
+	lease := rmap.NewLock(s.partitionsMap, partitionKey(p), 3*partitionCheckPeriod)
+	_, ok, err := lease.TryAcquire(ctx)
+	if err != nil {
+		s.logger.Error(fmt.Errorf("failed to acquire partition lease: %w", err), "partition", p)
+		return
+	}
+	if !ok {
+		// Previous owner has not released the partition yet.
+		return
+	}
+	sink, err := s.stream.Partitions[p].NewSink(ctx, s.Name, s.opts...)
+	if err != nil {
+		s.logger.Error(fmt.Errorf("failed to create partition sink: %w", err), "partition", p)
+		lease.Release(ctx) // nolint: errcheck
+		return
+	}
+	s.sinks[p] = sink
+	s.leases[p] = lease
+	c := sink.Subscribe()
+	pulse.Go(ctx, func() { s.forward(c) })
+	s.logger.Info("acquired", "partition", p)
+}
+
+// detachPartition removes the given partition from the partitions consumed by
+// the member and returns a function that closes its sink and releases its
+// lease. s.lock must be held. The returned function must be called once
+// s.lock is released as closing the sink waits for its read loop to exit.
+func (s *PartitionedSink) detachPartition(p int) func(context.Context) {
+	sink, lease := s.sinks[p], s.leases[p]
+	delete(s.sinks, p)
+	delete(s.leases, p)
+	return func(ctx context.Context) {
+		sink.Close(ctx)
+		if err := lease.Release(ctx); err != nil && err != rmap.ErrLockNotHeld {
+			s.logger.Error(fmt.Errorf("failed to release partition lease: %w", err), "partition", p)
+		}
+		s.logger.Info("released", "partition", p)
+	}
+}
+
+// forward sends the events received on c to the sink channels until c is
+// closed.
+func (s *PartitionedSink) forward(c <-chan *Event) {
+	for ev := range c {
+		s.subsLock.RLock()
+		for _, sub := range s.subs {
+			if !sub.send(ev) {
+				s.logger.Error(fmt.Errorf("subscriber too slow, closing subscription"), "id", ev.ID, "stream", ev.StreamName)
+			}
+		}
+		s.subsLock.RUnlock()
+	}
+}
+
+// partitionName returns the name of the stream that backs the given partition.
+func partitionName(name string, p int) string {
+	return fmt.Sprintf("%s:%d", name, p)
+}
+
+// partitionsMapName is the name of the replicated map that tracks the members
+// and partition leases of a partitioned sink.
+func partitionsMapName(stream, sink string) string {
+	return fmt.Sprintf("stream:%s:sink:%s:partitions", stream, sink)
+}
+
+// memberKeyPrefix is the prefix of the partitions map keys that record the
+// member keep-alives.
+const memberKeyPrefix = "member:"
+
+// memberKey returns the partitions map key that records the given member
+// keep-alive.
+func memberKey(member string) string {
+	return memberKeyPrefix + member
+}
+
+// partitionKey returns the partitions map key that stores the lease of the
+// given partition.
+func partitionKey(p int) string {
+	return fmt.Sprintf("partition:%d", p)
+}
@@ -0,0 +1,111 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/rmap"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestNewPartitionedStream(t *testing.T) {
+	ps, err := NewPartitionedStream("test", 4, nil)
+	require.NoError(t, err)
+	require.Len(t, ps.Partitions, 4)
+	for i, p := range ps.Partitions {
+		assert.Equal(t, partitionName("test", i), p.Name)
+	}
+	assert.Same(t, ps.Partition("key"), ps.Partition("key"))
+	seen := make(map[*Stream]struct{})
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		seen[ps.Partition(key)] = struct{}{}
+	}
+	assert.Greater(t, len(seen), 1)
+
+	_, err = NewPartitionedStream("test", 0, nil)
+	assert.Error(t, err)
+}
+
+func TestPartitionedSink(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	var origPeriod time.Duration
+	origPeriod, partitionCheckPeriod = partitionCheckPeriod, testCheckIdlePeriod
+	defer func() { partitionCheckPeriod = origPeriod }()
+
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	ps, err := NewPartitionedStream(testName, 4, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, ps.Destroy(ctx)) }()
+
+	opts := []options.Sink{options.WithSinkStartAtOldest(), options.WithSinkBlockDuration(testBlockDuration)}
+	sink, err := ps.NewSink(ctx, "sink", opts...)
+	require.NoError(t, err)
+	defer sink.Close(ctx)
+	assert.Eventually(t, func() bool { return len(sink.Partitions()) == 4 }, max, delay)
+
+	// Partitions are rebalanced when a consumer joins
+	sink2, err := ps.NewSink(ctx, "sink", opts...)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(sink.Partitions()) == 2 && len(sink2.Partitions()) == 2
+	}, max, delay)
+	assert.NotEqual(t, sink.Partitions(), sink2.Partitions())
+
+	// Events with the same key are delivered in order to the consumer that
+	// owns their partition
+	c := sink.Subscribe()
+	c2 := sink2.Subscribe()
+	owner, other := c, c2
+	p := ps.Partition("key")
+	if !containsPartition(sink, ps, p) {
+		owner, other = c2, c
+	}
+	for _, payload := range []string{"1", "2", "3"} {
+		_, err := ps.Add(ctx, "event", []byte(payload), options.WithOrderingKey("key"))
+		require.NoError(t, err)
+	}
+	for _, payload := range []string{"1", "2", "3"} {
+		select {
+		case ev := <-owner:
+			assert.Equal(t, payload, string(ev.Payload))
+			assert.Equal(t, p.Name, ev.StreamName)
+			assert.NoError(t, ev.sink.Ack(ctx, ev))
+		case <-time.After(max):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+	select {
+	case <-other:
+		t.Error("event delivered to consumer that does not own the partition")
+	default:
+	}
+
+	// Partitions are rebalanced when a consumer leaves
+	sink2.Close(ctx)
+	assert.Eventually(t, func() bool { return len(sink.Partitions()) == 4 }, max, delay)
+}
+
+func TestIsMembershipChange(t *testing.T) {
+	assert.True(t, isMembershipChange(&rmap.Change{Kind: rmap.EventChange}), "join")
+	assert.False(t, isMembershipChange(&rmap.Change{Kind: rmap.EventChange, Existed: true}), "keep-alive")
+	assert.True(t, isMembershipChange(&rmap.Change{Kind: rmap.EventDelete, Existed: true}), "leave")
+	assert.True(t, isMembershipChange(&rmap.Change{Kind: rmap.EventExpire, Existed: true}), "expire")
+	assert.True(t, isMembershipChange(&rmap.Change{Kind: rmap.EventResync}), "resync")
+}
+
+func containsPartition(s *PartitionedSink, ps *PartitionedStream, stream *Stream) bool {
+	for _, p := range s.Partitions() {
+		if ps.Partitions[p] == stream {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -40,8 +41,10 @@ type (
 		maxPolled int64
 		// buffer size of the reader channel.
 		bufferSize int
-		// channels to send notifications
-		chans []chan *Event
+		// subs are the reader subscriptions.
+		subs []*subscription
+		// index indexes subs so that Unsubscribe does not wait for lock.
+		index subscriptionIndex
 		// donechan is the reader donechan channel.
 		donechan chan struct{}
 		// streamschan notifies the reader when streams are added or
@@ -53,10 +56,37 @@ type (
 		closing bool
 		// eventFilter is the event filter if any.
 		eventFilter eventFilterFunc
+		// name is the durable reader name, empty if the reader is not
+		// durable.
+		name string
+		// cursorsKey is the key of the hash that stores the durable
+		// reader checkpoints indexed by stream name.
+		cursorsKey string
+		// checkpointInterval is the interval at which the durable reader
+		// checkpoints its cursors, 0 if only on commit.
+		checkpointInterval time.Duration
+		// ckLock protects the checkpoint state below. It is never held
+		// while sending events to the subscriptions so that subscribers
+		// may call Commit at any time.
+		ckLock sync.Mutex
+		// committed is the last checkpointed event ID indexed by stream
+		// name.
+		committed map[string]string
+		// tracked are the subscriptions whose consumption is tracked for
+		// periodic checkpoints.
+		tracked []*subscription
+		// sent are the events sent to the subscriptions that may not have
+		// been consumed yet, oldest first.
+		sent []sentEvent
+		// sentCount is the number of events sent to the subscriptions.
+		sentCount uint64
+		// consumed is the ID of the last event consumed by all the
+		// subscriptions indexed by stream name.
+		consumed map[string]string
 		// logger is the logger used by the reader.
 		logger pulse.Logger
 		// rdb is the redis connection.
-		rdb *redis.Client
+		rdb redis.UniversalClient
 	}
 
 	// Acker is the interface used by events to acknowledge themselves.
@@ -78,71 +108,133 @@ type (
 		Topic string
 		// Payload is the event payload.
 		Payload []byte
+		// OrderingKey is the producer-defined event ordering key if any,
+		// empty string if none.
+		OrderingKey string
+		// SchemaVersion is the producer-defined version of the payload
+		// schema if any, 0 if none.
+		SchemaVersion int
+		// Headers are the producer-defined event headers if any, nil if
+		// none.
+		Headers map[string]string
 		// Acker is the redis client used to acknowledge events.
 		Acker Acker
 		// streamKey is the Redis key of the stream.
 		streamKey string
+		// sink is the sink the event was read from, nil if the event
+		// was read from a reader.
+		sink *Sink
+		// seq is the sequence number of the event among the events sent
+		// by a durable reader, see Reader.track.
+		seq uint64
+		// prevKeyedID is the ID of the previous event added with the same
+		// ordering key if any.
+		prevKeyedID string
+	}
+
+	// sentEvent identifies an event sent by a durable reader.
+	sentEvent struct {
+		seq    uint64
+		stream string
+		id     string
 	}
 )
 
 // newReader creates a new reader.
 func newReader(ctx context.Context, stream *Stream, opts ...options.Reader) (*Reader, error) {
 	o := options.ParseReaderOptions(opts...)
-	var eventFilter eventFilterFunc
-	if o.Topic != "" {
-		eventFilter = func(e *Event) bool { return e.Topic == o.Topic }
-	} else if o.TopicPattern != "" {
-		topicPatternRegexp := regexp.MustCompile(o.TopicPattern)
-		eventFilter = func(e *Event) bool { return topicPatternRegexp.MatchString(e.Topic) }
-	}
+	eventFilter := newEventFilter(o.Topic, o.TopicPattern, o.Headers)
 
 	reader := &Reader{
-		startID:       o.LastEventID,
-		streams:       []*Stream{stream},
-		streamKeys:    []string{stream.key},
-		streamCursors: []string{o.LastEventID},
-		blockDuration: o.BlockDuration,
-		maxPolled:     o.MaxPolled,
-		bufferSize:    o.BufferSize,
-		donechan:      make(chan struct{}),
-		streamschan:   make(chan struct{}),
-		eventFilter:   eventFilter,
-		logger:        stream.rootLogger.WithPrefix("reader", stream.Name),
-		rdb:           stream.rdb,
+		startID:            o.LastEventID,
+		streams:            []*Stream{stream},
+		streamKeys:         []string{stream.key},
+		streamCursors:      []string{o.LastEventID},
+		blockDuration:      o.BlockDuration,
+		maxPolled:          o.MaxPolled,
+		bufferSize:         o.BufferSize,
+		donechan:           make(chan struct{}),
+		streamschan:        make(chan struct{}),
+		eventFilter:        eventFilter,
+		name:               o.Name,
+		checkpointInterval: o.Checkpoint,
+		committed:          make(map[string]string),
+		consumed:           make(map[string]string),
+		logger:             stream.rootLogger.WithPrefix("reader", stream.Name),
+		rdb:                stream.rdb,
+	}
+	if o.Name != "" {
+		if !isValidRedisKeyName(o.Name) {
+			return nil, fmt.Errorf("not a valid reader name %q", o.Name)
+		}
+		reader.cursorsKey = readerCursorsKey(o.Name)
+		reader.logger = reader.logger.WithPrefix("name", o.Name)
+		startID, err := reader.loadCheckpoint(ctx, stream, o.LastEventID)
+		if err != nil {
+			return nil, err
+		}
+		reader.streamCursors[0] = startID
 	}
 
+	stream.startScheduler()
 	reader.wait.Add(1)
 	pulse.Go(ctx, reader.read)
+	if reader.tracksConsumption() {
+		reader.wait.Add(1)
+		pulse.Go(ctx, reader.periodicCheckpoint)
+	}
 
 	return reader, nil
 }
 
 // Subscribe returns a channel that receives events from the stream.
-// The channel is closed when the reader is closed.
-func (r *Reader) Subscribe() <-chan *Event {
-	c := make(chan *Event, r.bufferSize)
+// The channel is closed when the reader is closed or, if the OverflowFail
+// policy is used, when the channel buffer is full.
+func (r *Reader) Subscribe(opts ...options.Subscribe) <-chan *Event {
+	sub := newSubscription(r.bufferSize, opts...)
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	r.chans = append(r.chans, c)
-	return c
+	r.subs = append(r.subs, sub)
+	r.index.add(sub)
+	if r.tracksConsumption() {
+		sub.track = true
+		r.ckLock.Lock()
+		sub.first = r.sentCount + 1
+		r.tracked = append(r.tracked, sub)
+		r.ckLock.Unlock()
+	}
+	return sub.c
 }
 
 // Unsubscribe removes the channel from the reader subscribers and closes it.
 func (r *Reader) Unsubscribe(c <-chan *Event) {
+	r.index.cancel(c) // Interrupt any send blocked on c
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	for i, ch := range r.chans {
-		if ch == c {
-			close(ch)
-			r.chans = append(r.chans[:i], r.chans[i+1:]...)
-			return
-		}
+	if i := findSubscription(r.subs, c); i >= 0 {
+		r.subs[i].close()
+		r.subs = append(r.subs[:i], r.subs[i+1:]...)
+	}
+	r.ckLock.Lock()
+	defer r.ckLock.Unlock()
+	if i := findSubscription(r.tracked, c); i >= 0 {
+		r.tracked = append(r.tracked[:i], r.tracked[i+1:]...)
 	}
 }
 
+// Dropped returns the number of events dropped by the subscription with
+// channel c because its buffer was full, see WithSubscribeOverflow.
+func (r *Reader) Dropped(c <-chan *Event) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return dropped(r.subs, c)
+}
+
 // AddStream adds the stream to the sink. By default the stream cursor starts at
 // the same timestamp as the sink main stream cursor.  This can be overridden
 // with opts. AddStream does nothing if the stream is already part of the sink.
+// When using Redis Cluster the stream name must share the same hash tag as the
+// other streams of the reader, see NewStream.
 func (r *Reader) AddStream(ctx context.Context, stream *Stream, opts ...options.AddStream) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -156,7 +248,14 @@ func (r *Reader) AddStream(ctx context.Context, stream *Stream, opts ...options.
 	if o.LastEventID != "" {
 		startID = o.LastEventID
 	}
+	if r.name != "" {
+		var err error
+		if startID, err = r.loadCheckpoint(ctx, stream, startID); err != nil {
+			return err
+		}
+	}
 	r.streams = append(r.streams, stream)
+	stream.startScheduler()
 	r.streamKeys = append(r.streamKeys, stream.key)
 	r.streamCursors = append(r.streamCursors, startID)
 	r.notifyStreamChange()
@@ -171,6 +270,7 @@ func (r *Reader) RemoveStream(ctx context.Context, stream *Stream) error {
 	for i, st := range r.streams {
 		if st == stream {
 			r.streams = append(r.streams[:i], r.streams[i+1:]...)
+			stream.stopScheduler()
 			r.streamKeys = append(r.streamKeys[:i], r.streamKeys[i+1:]...)
 			r.streamCursors = append(r.streamCursors[:i], r.streamCursors[i+1:]...)
 			break
@@ -193,8 +293,16 @@ func (r *Reader) Close() {
 	close(r.streamschan)
 	r.lock.Unlock()
 	r.wait.Wait()
+	if r.tracksConsumption() {
+		if err := r.checkpoint(context.Background()); err != nil {
+			r.logger.Error(fmt.Errorf("failed to checkpoint cursors: %w", err))
+		}
+	}
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	for _, stream := range r.streams {
+		stream.stopScheduler()
+	}
 	r.closed = true
 	r.logger.Info("stopped")
 }
@@ -224,18 +332,62 @@ func (r *Reader) read() {
 			continue
 		}
 
+		// Load the events before taking the lock as payloads may have to be
+		// fetched from the blob store.
+		r.lock.Lock()
+		streams := make([]*Stream, len(streamsEvents))
+		for i, events := range streamsEvents {
+			streams[i] = streamByKey(r.streams, events.Stream)
+		}
+		r.lock.Unlock()
+		loaded := make([][]*Event, len(streamsEvents))
+		processed := make([]int, len(streamsEvents))
+		failed := false
+		for i, events := range streamsEvents {
+			if streams[i] == nil {
+				// Stream was removed while reading.
+				continue
+			}
+			loaded[i], processed[i], err = loadEvents(ctx, streams[i], nil, events.Messages, r.eventFilter, r.rdb, r.logger)
+			if err != nil {
+				// Do not advance the cursor past the event so that it
+				// gets read again.
+				r.logger.Error(err, "stream", streams[i].Name)
+				failed = true
+			}
+		}
+
 		r.lock.Lock()
-		for _, events := range streamsEvents {
-			streamName := events.Stream[len(streamKeyPrefix):]
-			streamEvents(streamName, events.Stream, "", events.Messages, r.eventFilter, r.chans, r.rdb, r.logger)
-			for i := range r.streamKeys {
-				if r.streamKeys[i] == events.Stream {
-					r.streamCursors[i] = events.Messages[len(events.Messages)-1].ID
+		for i, events := range streamsEvents {
+			if streams[i] == nil || streamByKey(r.streams, events.Stream) == nil {
+				// Stream was removed while reading.
+				continue
+			}
+			var track func(*Event)
+			if r.tracksConsumption() {
+				track = r.track
+			}
+			streamEvents(streams[i], loaded[i], r.subs, track, r.logger)
+			if processed[i] == 0 {
+				continue
+			}
+			for j := range r.streamKeys {
+				if r.streamKeys[j] == events.Stream {
+					r.streamCursors[j] = events.Messages[processed[i]-1].ID
 					break
 				}
 			}
 		}
+		if r.tracksConsumption() {
+			r.ckLock.Lock()
+			r.collectConsumed()
+			r.ckLock.Unlock()
+		}
 		r.lock.Unlock()
+		if failed {
+			// Back off before reading the events that failed to load again.
+			time.Sleep(r.blockDuration)
+		}
 	}
 }
 
@@ -269,10 +421,11 @@ func (r *Reader) notifyStreamChange() {
 func (r *Reader) cleanup() {
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	for _, c := range r.chans {
-		close(c)
+	for _, sub := range r.subs {
+		sub.close()
 	}
-	r.chans = nil
+	r.subs = nil
+	r.index.cancelAll()
 	r.wait.Done()
 }
 
@@ -292,45 +445,180 @@ func (e *Event) CreatedAt() time.Time {
 	return time.Unix(seconds, nanos).UTC()
 }
 
-// streamEvents filters and streams the Redis messages as events to c.
-// The caller is responsible for locking c.
-func streamEvents(
-	streamName string,
-	streamKey string,
-	sinkName string,
+// Retry negatively acknowledges the event so that it is redelivered after the
+// given delay, see Sink.Nack. Retry returns an error if the event was not read
+// from a sink.
+func (e *Event) Retry(after time.Duration) error {
+	if e.sink == nil {
+		return fmt.Errorf("cannot retry event %s: event was not read from a sink", e.ID)
+	}
+	return e.sink.Nack(context.Background(), e, after)
+}
+
+// loadEvents filters the Redis messages and returns the corresponding events
+// with their payloads. Payloads may have to be fetched from the blob store so
+// loadEvents must be called without holding the reader or sink lock. It stops
+// at the first message whose payload cannot be loaded and returns the events
+// that precede it along with the number of messages processed and the error.
+// Messages filtered out by a sink are acknowledged so that they do not stay
+// pending, which would block the events that follow them with the same
+// ordering key.
+func loadEvents(
+	ctx context.Context,
+	stream *Stream,
+	sink *Sink,
 	msgs []redis.XMessage,
 	eventFilter eventFilterFunc,
-	chans []chan *Event,
-	rdb *redis.Client,
+	rdb redis.UniversalClient,
 	logger pulse.Logger,
-) {
-	if len(msgs) == 0 {
-		return
+) ([]*Event, int, error) {
+	var sinkName string
+	if sink != nil {
+		sinkName = sink.Name
 	}
-	for _, event := range msgs {
-		var topic string
+	events := make([]*Event, 0, len(msgs))
+	var filtered []string
+	if sink != nil && !sink.noAck {
+		defer func() {
+			if len(filtered) == 0 {
+				return
+			}
+			if err := rdb.XAck(ctx, stream.key, sink.Name, filtered...).Err(); err != nil {
+				logger.Error(fmt.Errorf("failed to ack filtered events: %w", err), "stream", stream.Name)
+			}
+		}()
+	}
+	for i, event := range msgs {
+		var topic, orderingKey string
 		if t, ok := event.Values[topicKey]; ok {
 			topic = t.(string)
 		}
+		if k, ok := event.Values[orderingKeyKey]; ok {
+			orderingKey = k.(string)
+		}
+		var prevKeyedID string
+		if p, ok := event.Values[prevKeyedKey]; ok {
+			prevKeyedID = p.(string)
+		}
+		var version int
+		if v, ok := event.Values[schemaVersionKey]; ok {
+			version, _ = strconv.Atoi(v.(string))
+		}
 		ev := &Event{
-			ID:         event.ID,
-			StreamName: streamName,
-			SinkName:   sinkName,
-			EventName:  event.Values[nameKey].(string),
-			Topic:      topic,
-			Payload:    []byte(event.Values[payloadKey].(string)),
-			streamKey:  streamKey,
-			Acker:      rdb,
+			ID:            event.ID,
+			StreamName:    stream.Name,
+			SinkName:      sinkName,
+			EventName:     event.Values[nameKey].(string),
+			Topic:         topic,
+			OrderingKey:   orderingKey,
+			SchemaVersion: version,
+			Headers:       parseHeaders(event.Values),
+			streamKey:     stream.key,
+			sink:          sink,
+			prevKeyedID:   prevKeyedID,
+			Acker:         rdb,
 		}
 		if eventFilter != nil && !eventFilter(ev) {
-			logger.Debug("event filtered", "event", ev.EventName, "id", ev.ID, "stream", streamName)
+			logger.Debug("event filtered", "event", ev.EventName, "id", ev.ID, "stream", stream.Name)
+			if sink != nil {
+				filtered = append(filtered, ev.ID)
+			}
+			continue
+		}
+		// Decode the payload only once the event is known to be delivered
+		// as it may have to be fetched from the blob store.
+		payload, err := stream.loadPayload(ctx, event.Values)
+		if err != nil {
+			return events, i, fmt.Errorf("failed to decode event payload of %s: %w", event.ID, err)
+		}
+		ev.Payload = payload
+		events = append(events, ev)
+	}
+	return events, len(msgs), nil
+}
+
+// streamEvents streams the events to the subscriptions. track is called with
+// each event prior to sending it if not nil. The caller is responsible for
+// locking subs.
+func streamEvents(stream *Stream, events []*Event, subs []*subscription, track func(*Event), logger pulse.Logger) {
+	for _, ev := range events {
+		if track != nil {
+			track(ev)
+		}
+		logger.Debug("event", "stream", stream.Name, "event", ev.EventName, "id", ev.ID, "channels", len(subs))
+		for _, sub := range subs {
+			if !sub.send(ev) {
+				logger.Error(fmt.Errorf("subscriber too slow, closing subscription"), "id", ev.ID, "stream", stream.Name)
+			}
+		}
+	}
+}
+
+// streamByKey returns the stream with the given Redis key, nil if none.
+func streamByKey(streams []*Stream, key string) *Stream {
+	for _, stream := range streams {
+		if stream.key == key {
+			return stream
+		}
+	}
+	return nil
+}
+
+// newEventFilter returns the filter that matches events with the given topic
+// or topic pattern and headers, nil if there is no filter.
+// topicPattern must be a valid regular expression.
+func newEventFilter(topic, topicPattern string, headers map[string]string) eventFilterFunc {
+	var topicFilter eventFilterFunc
+	if topic != "" {
+		topicFilter = func(e *Event) bool { return e.Topic == topic }
+	} else if topicPattern != "" {
+		topicPatternRegexp := regexp.MustCompile(topicPattern)
+		topicFilter = func(e *Event) bool { return topicPatternRegexp.MatchString(e.Topic) }
+	}
+	if len(headers) == 0 {
+		return topicFilter
+	}
+	return func(e *Event) bool {
+		if topicFilter != nil && !topicFilter(e) {
+			return false
+		}
+		for k, v := range headers {
+			if hv, ok := e.Headers[k]; !ok || hv != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// appendHeaders appends the Redis stream entry values that store the given
+// headers to values. Headers are sorted by key.
+func appendHeaders(values []any, headers map[string]string) []any {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values = append(values, headerKeyPrefix+k, headers[k])
+	}
+	return values
+}
+
+// parseHeaders returns the headers stored in the given Redis stream entry
+// values, nil if there is none.
+func parseHeaders(values map[string]any) map[string]string {
+	var headers map[string]string
+	for k, v := range values {
+		if !strings.HasPrefix(k, headerKeyPrefix) {
 			continue
 		}
-		logger.Debug("event", "stream", streamName, "event", ev.EventName, "id", ev.ID, "channels", len(chans))
-		for _, c := range chans {
-			c <- ev
+		if headers == nil {
+			headers = make(map[string]string)
 		}
+		headers[k[len(headerKeyPrefix):]] = v.(string)
 	}
+	return headers
 }
 
 // handleReadError retries retryable read errors and ignores non-retryable.
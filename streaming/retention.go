@@ -0,0 +1,132 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// trimPeriod is the period at which streams configured with a maximum age or
+// to keep unacknowledged events are trimmed.
+var trimPeriod = 5 * time.Second
+
+// keepUnackedMaxLenFactor is the factor applied to the maximum length of
+// streams that keep unacknowledged events to compute the length they are
+// capped to when events are added, see addMaxLen.
+const keepUnackedMaxLenFactor = 10
+
+// trimsPeriodically returns true if the stream must be trimmed by the stream
+// scheduler.
+func (s *Stream) trimsPeriodically() bool {
+	return s.MaxAge > 0 || s.keepUnacked
+}
+
+// addMaxLen returns the maximum length applied when events are added. Streams
+// that keep unacknowledged events are trimmed periodically instead, they are
+// only capped to keepUnackedMaxLenFactor times their maximum length so that
+// they do not grow without bound while no sink or reader trims them.
+func (s *Stream) addMaxLen() int {
+	if s.keepUnacked {
+		return keepUnackedMaxLenFactor * s.MaxLen
+	}
+	return s.MaxLen
+}
+
+// maxAgeMinID returns the smallest ID of the events that are not older than
+// the stream maximum age.
+func (s *Stream) maxAgeMinID() string {
+	return fmt.Sprintf("%d-0", time.Now().Add(-s.MaxAge).UnixMilli())
+}
+
+// trim removes the events older than the stream maximum age. If the stream was
+// created with WithStreamKeepUnacked then trim also removes the events that
+// exceed the stream maximum length but never removes events that have not
+// been delivered to or acknowledged by all the stream consumer groups.
+func (s *Stream) trim(ctx context.Context) error {
+	var cuts []string
+	if s.MaxAge > 0 {
+		cuts = append(cuts, s.maxAgeMinID())
+	}
+	if s.keepUnacked && s.MaxLen > 0 {
+		msgs, err := s.rdb.XRevRangeN(ctx, s.key, "+", "-", int64(s.MaxLen)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+		if len(msgs) == s.MaxLen {
+			cuts = append(cuts, msgs[len(msgs)-1].ID)
+		}
+	}
+	if len(cuts) == 0 {
+		return nil
+	}
+	var floors []string
+	if s.keepUnacked {
+		var err error
+		floors, err = s.unackedFloors(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	minID := retentionMinID(cuts, floors)
+	if minID == "" {
+		return nil
+	}
+	trimmed, err := s.rdb.XTrimMinID(ctx, s.key, minID).Result()
+	if err != nil {
+		return err
+	}
+	if trimmed > 0 {
+		s.logger.Debug("trimmed", "events", trimmed, "min-id", minID)
+	}
+	return nil
+}
+
+// unackedFloors returns, for each consumer group of the stream, the ID of the
+// oldest event that the group may still need: the oldest pending event if any
+// and the last event delivered to the group otherwise.
+func (s *Stream) unackedFloors(ctx context.Context) ([]string, error) {
+	groups, err := s.rdb.XInfoGroups(ctx, s.key).Result()
+	if err != nil {
+		if isNoSuchKeyErr(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get stream groups: %w", err)
+	}
+	floors := make([]string, 0, len(groups))
+	for _, g := range groups {
+		floor := g.LastDeliveredID
+		if g.Pending > 0 {
+			pending, err := s.rdb.XPending(ctx, s.key, g.Name).Result()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pending events for %q: %w", g.Name, err)
+			}
+			if pending.Count > 0 && compareEventIDs(pending.Lower, floor) < 0 {
+				floor = pending.Lower
+			}
+		}
+		floors = append(floors, floor)
+	}
+	return floors, nil
+}
+
+// retentionMinID returns the ID of the oldest event to keep given the
+// retention cuts (events with smaller IDs may be removed) and the floors
+// (events with IDs greater or equal must be kept). It returns the empty string
+// if no event may be removed.
+func retentionMinID(cuts, floors []string) string {
+	var minID string
+	for _, cut := range cuts {
+		if minID == "" || compareEventIDs(cut, minID) > 0 {
+			minID = cut
+		}
+	}
+	for _, floor := range floors {
+		if compareEventIDs(floor, minID) < 0 {
+			minID = floor
+		}
+	}
+	if minID == "0-0" {
+		return ""
+	}
+	return minID
+}
@@ -0,0 +1,106 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestMaxAge(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	maxAge := 100 * time.Millisecond
+	s, err := NewStream(testName, rdb,
+		options.WithStreamMaxAge(maxAge),
+		options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+
+	_, err = s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+	time.Sleep(2 * maxAge)
+	require.NoError(t, s.trim(ctx))
+	info, err := s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Length)
+}
+
+func TestMaxAgeKeepUnacked(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	maxAge := 100 * time.Millisecond
+	s, err := NewStream(testName, rdb,
+		options.WithStreamMaxAge(maxAge),
+		options.WithStreamKeepUnacked(),
+		options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink", options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	c := sink.Subscribe()
+
+	// Pending events are not trimmed
+	_, err = s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+	var read *Event
+	select {
+	case read = <-c:
+	case <-time.After(max):
+		t.Fatal("timeout waiting for event")
+	}
+	id2, err := s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+	time.Sleep(2 * maxAge)
+	require.NoError(t, s.trim(ctx))
+	info, err := s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.Length)
+
+	// Acked events are trimmed, the last delivered event is kept
+	require.NoError(t, sink.Ack(ctx, read))
+	readOneEvent(t, ctx, c, sink)
+	time.Sleep(2 * maxAge)
+	require.NoError(t, s.trim(ctx))
+	info, err = s.Info(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), info.Length)
+	assert.Equal(t, id2, info.FirstID)
+}
+
+func TestAddMaxLen(t *testing.T) {
+	assert.Equal(t, 100, (&Stream{MaxLen: 100}).addMaxLen())
+	assert.Equal(t, 100*keepUnackedMaxLenFactor, (&Stream{MaxLen: 100, keepUnacked: true}).addMaxLen())
+	assert.Zero(t, (&Stream{keepUnacked: true}).addMaxLen(), "unbounded stream")
+}
+
+func TestRetentionMinID(t *testing.T) {
+	cases := []struct {
+		name   string
+		cuts   []string
+		floors []string
+		want   string
+	}{
+		{"no cut", nil, []string{"1-0"}, ""},
+		{"single cut", []string{"5-0"}, nil, "5-0"},
+		{"newest cut", []string{"5-0", "7-0"}, nil, "7-0"},
+		{"floor after cut", []string{"5-0"}, []string{"6-0"}, "5-0"},
+		{"floor before cut", []string{"5-0"}, []string{"6-0", "3-1"}, "3-1"},
+		{"nothing delivered", []string{"5-0"}, []string{"0-0"}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, retentionMinID(c.cuts, c.floors))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Nack negatively acknowledges the event so that it is redelivered after the
+// given delay instead of after the sink ack grace period. If delay is 0 then
+// the delay is computed using the sink retry backoff policy, see
+// options.WithSinkRetryBackoff. Redeliveries happen at the granularity of the
+// sink idle message check period. Nack does not schedule a redelivery if the
+// event reached the maximum number of deliveries, the event is moved to the
+// dead-letter stream once the ack grace period elapses instead, see
+// options.WithSinkMaxDeliveries.
+func (s *Sink) Nack(ctx context.Context, e *Event, delay time.Duration) error {
+	if s.noAck {
+		return fmt.Errorf("cannot nack event %s: sink %s does not acknowledge events", e.ID, s.Name)
+	}
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: e.streamKey,
+		Group:  s.Name,
+		Start:  e.ID,
+		End:    e.ID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		err = fmt.Errorf("failed to nack event %s: %w", e.ID, err)
+		s.logger.Error(err, "stream", e.StreamName)
+		return err
+	}
+	if len(pending) == 0 {
+		return fmt.Errorf("failed to nack event %s: event is not pending", e.ID)
+	}
+	deliveries := pending[0].RetryCount
+	if s.maxDeliveries > 0 && deliveries >= s.maxDeliveries {
+		s.logger.Debug("nacked", "event", e.ID, "stream", e.StreamName, "deliveries", deliveries, "max_deliveries", s.maxDeliveries)
+		return nil
+	}
+	if delay == 0 {
+		delay = s.retryDelay(deliveries)
+	}
+	deadline := time.Now().Add(delay).UnixMilli()
+	member := redis.Z{Score: float64(deadline), Member: eventField(e.StreamName, e.ID)}
+	if err := s.rdb.ZAdd(ctx, s.retriesKey, member).Err(); err != nil {
+		err = fmt.Errorf("failed to nack event %s: %w", e.ID, err)
+		s.logger.Error(err, "stream", e.StreamName)
+		return err
+	}
+	s.logger.Debug("nacked", "event", e.ID, "stream", e.StreamName, "deliveries", deliveries, "delay", delay)
+	return nil
+}
+
+// retryDelay returns the redelivery delay of an event that was delivered the
+// given number of times according to the sink retry backoff policy.
+func (s *Sink) retryDelay(deliveries int64) time.Duration {
+	if s.retryInitial <= 0 {
+		return 0
+	}
+	factor := math.Max(s.retryFactor, 1)
+	d := float64(s.retryInitial) * math.Pow(factor, float64(deliveries-1))
+	if s.retryMax > 0 && d > float64(s.retryMax) {
+		d = float64(s.retryMax)
+	}
+	if s.retryJitter > 0 {
+		d += d * math.Min(s.retryJitter, 1) * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retryNackedMessages redelivers the nacked messages whose delay elapsed. It
+// also resets the idle time of the other nacked messages so that they do not
+// get claimed before their delay elapses. Messages that are no longer pending
+// are removed from the retries sorted set.
+// s.lock must be held.
+func (s *Sink) retryNackedMessages(ctx context.Context) error {
+	retries, err := s.rdb.ZRangeWithScores(ctx, s.retriesKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list nacked messages: %w", err)
+	}
+	if len(retries) == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	due := make(map[string][]string)
+	waiting := make(map[string][]string)
+	for _, z := range retries {
+		streamName, id := parseEventField(z.Member.(string))
+		if int64(z.Score) <= now {
+			due[streamName] = append(due[streamName], id)
+		} else {
+			waiting[streamName] = append(waiting[streamName], id)
+		}
+	}
+	for _, stream := range s.streams {
+		if ids := waiting[stream.Name]; len(ids) > 0 {
+			// Claiming with JUSTID resets the idle time without
+			// incrementing the delivery count.
+			claimed, err := s.rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+				Stream:   stream.key,
+				Group:    s.Name,
+				Consumer: s.consumer,
+				Messages: ids,
+			}).Result()
+			if err != nil {
+				return fmt.Errorf("failed to reset idle time of nacked messages: %w", err)
+			}
+			if err := s.removeRetries(ctx, stream.Name, without(ids, claimed)); err != nil {
+				return err
+			}
+		}
+		if ids := due[stream.Name]; len(ids) > 0 {
+			msgs, err := s.rdb.XClaim(ctx, &redis.XClaimArgs{
+				Stream:   stream.key,
+				Group:    s.Name,
+				Consumer: s.consumer,
+				Messages: ids,
+			}).Result()
+			if err != nil {
+				return fmt.Errorf("failed to claim nacked messages: %w", err)
+			}
+			if err := s.removeRetries(ctx, stream.Name, ids); err != nil {
+				return err
+			}
+			if len(msgs) > 0 {
+				s.logger.Info("redelivered", "stream", stream.Name, "messages", len(msgs))
+				s.deliver(ctx, stream, msgs)
+			}
+		}
+	}
+	return nil
+}
+
+// removeRetries removes the given messages from the retries sorted set.
+func (s *Sink) removeRetries(ctx context.Context, streamName string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]any, len(ids))
+	for i, id := range ids {
+		members[i] = eventField(streamName, id)
+	}
+	if err := s.rdb.ZRem(ctx, s.retriesKey, members...).Err(); err != nil {
+		return fmt.Errorf("failed to remove nacked messages: %w", err)
+	}
+	return nil
+}
+
+// without returns the elements of ids that are not in exclude.
+func without(ids, exclude []string) []string {
+	excluded := make(map[string]struct{}, len(exclude))
+	for _, id := range exclude {
+		excluded[id] = struct{}{}
+	}
+	var res []string
+	for _, id := range ids {
+		if _, ok := excluded[id]; !ok {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// sinkRetriesKey is the key of the sorted set that stores the redelivery
+// deadlines of the events negatively acknowledged by a sink.
+func sinkRetriesKey(sink string) string {
+	return fmt.Sprintf("sink:%s:retries", sink)
+}
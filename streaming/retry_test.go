@@ -0,0 +1,92 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestNack(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	var origStalePeriod time.Duration
+	origStalePeriod, checkIdlePeriod = checkIdlePeriod, testCheckIdlePeriod
+	defer func() { checkIdlePeriod = origStalePeriod }()
+
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	assert.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkAckGracePeriod(time.Minute),
+		options.WithSinkRetryBackoff(2*testCheckIdlePeriod, time.Minute, 2, 0))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	defer func() { assert.NoError(t, rdb.Del(ctx, sinkRetriesKey("sink")).Err()) }()
+
+	c := sink.Subscribe()
+	id, err := s.Add(ctx, "event", []byte("payload"))
+	require.NoError(t, err)
+	read := readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, id, read.ID)
+
+	// Nacked event is redelivered after the delay, well before the ack grace
+	// period elapses
+	start := time.Now()
+	require.NoError(t, sink.Nack(ctx, read, 4*testCheckIdlePeriod))
+	read = readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, id, read.ID)
+	assert.GreaterOrEqual(t, time.Since(start), 4*testCheckIdlePeriod)
+
+	// Retried event uses the backoff policy
+	start = time.Now()
+	require.NoError(t, read.Retry(0))
+	read = readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, id, read.ID)
+	assert.GreaterOrEqual(t, time.Since(start), 4*testCheckIdlePeriod)
+
+	// Acked event cannot be nacked
+	require.NoError(t, sink.Ack(ctx, read))
+	assert.Error(t, sink.Nack(ctx, read, 0))
+	select {
+	case <-c:
+		t.Error("acked event redelivered")
+	case <-time.After(4 * testCheckIdlePeriod):
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cases := []struct {
+		name       string
+		sink       *Sink
+		deliveries int64
+		want       time.Duration
+	}{
+		{"no backoff", &Sink{}, 3, 0},
+		{"first", &Sink{retryInitial: time.Second, retryFactor: 2}, 1, time.Second},
+		{"third", &Sink{retryInitial: time.Second, retryFactor: 2}, 3, 4 * time.Second},
+		{"max", &Sink{retryInitial: time.Second, retryFactor: 2, retryMax: 3 * time.Second}, 3, 3 * time.Second},
+		{"no factor", &Sink{retryInitial: time.Second}, 3, time.Second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, c.sink.retryDelay(c.deliveries))
+		})
+	}
+
+	s := &Sink{retryInitial: time.Second, retryFactor: 2, retryJitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := s.retryDelay(2)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 3*time.Second)
+	}
+}
@@ -0,0 +1,242 @@
+package streaming
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+)
+
+var (
+	// schedulerPeriod is the period at which scheduled events are checked.
+	schedulerPeriod = 500 * time.Millisecond
+	// maxScheduledMoved is the maximum number of scheduled events added to
+	// the stream in one scheduler run.
+	maxScheduledMoved = 1000
+)
+
+// scheduleScript is the script used to add an event to the scheduled events
+// sorted set. The event is due ARGV[1] milliseconds after the current Redis
+// time so that the delivery time does not depend on the clocks of the
+// processes that schedule and move the events.
+var scheduleScript = redis.NewScript(`
+    local t = redis.call("TIME")
+    local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+    redis.call("ZADD", KEYS[1], now + tonumber(ARGV[1]), ARGV[2])
+`)
+
+// moveScheduledScript is the script used to add the due scheduled events to
+// the stream. It only moves events if the caller holds the scheduler lease
+// (acquiring it if needed) so that only one scheduler is active at a time.
+// Events are due according to the Redis clock, see scheduleScript.
+// Scheduled events are encoded using the struct.pack "ic0" format: the first
+// string is the scheduled event ID followed by the stream entry field names
+// and values. Events with an ordering key record the ID of the previous event
+// added with the same key like addScript does. The offloaded payloads of the
+// moved events are tracked with the time of their stream ID, see
+// trackScheduledBlob.
+var moveScheduledScript = redis.NewScript(`
+    local scheduled = KEYS[1]
+    local stream = KEYS[2]
+    local lease = KEYS[3]
+    local ordering = KEYS[4]
+    local blobs = KEYS[5]
+    local owner = ARGV[1]
+
+    local current = redis.call("GET", lease)
+    if current ~= false and current ~= owner then
+        return -1
+    end
+    redis.call("SET", lease, owner, "PX", ARGV[2])
+
+    local t = redis.call("TIME")
+    local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+    local due = redis.call("ZRANGEBYSCORE", scheduled, "-inf", now, "LIMIT", 0, ARGV[3])
+    for _, event in ipairs(due) do
+        local args = {stream}
+        if tonumber(ARGV[4]) > 0 then
+            table.insert(args, "MAXLEN")
+            table.insert(args, "~")
+            table.insert(args, ARGV[4])
+        end
+        table.insert(args, "*")
+        local pos = 1
+        local _, field, value, orderingKey, blob
+        _, pos = struct.unpack("ic0", event, pos) -- skip ID
+        while pos <= string.len(event) do
+            field, pos = struct.unpack("ic0", event, pos)
+            value, pos = struct.unpack("ic0", event, pos)
+            table.insert(args, field)
+            table.insert(args, value)
+            if field == "k" then
+                orderingKey = value
+            elseif field == "b" then
+                blob = value
+            end
+        end
+        if orderingKey then
+            local prev = redis.call("HGET", ordering, orderingKey)
+            if prev then
+                table.insert(args, "kp")
+                table.insert(args, prev)
+            end
+        end
+        local id = redis.call("XADD", unpack(args))
+        if orderingKey then
+            redis.call("HSET", ordering, orderingKey, id)
+        end
+        if blob then
+            redis.call("ZADD", blobs, string.match(id, "^%d+"), blob)
+        end
+        redis.call("ZREM", scheduled, event)
+    end
+    return #due
+`)
+
+// schedule stores the event with the given values in the stream scheduled
+// events sorted set and returns the scheduled event ID. The event payload is
+// offloaded to the blob store when it exceeds the claim-check threshold.
+func (s *Stream) schedule(ctx context.Context, name string, values []any, o options.AddEventOptions) (string, error) {
+	if o.OnlyIfStreamExists && o.IdempotencyKey == "" {
+		n, err := s.rdb.Exists(ctx, s.key).Result()
+		if err != nil {
+			err = fmt.Errorf("failed to schedule event: %w", err)
+			s.logger.Error(err, "event", name)
+			return "", err
+		}
+		if n == 0 {
+			return "", nil
+		}
+	}
+	values, blob, err := s.offload(ctx, values)
+	if err != nil {
+		err = fmt.Errorf("failed to schedule event: %w", err)
+		s.logger.Error(err, "event", name)
+		return "", err
+	}
+	if err := s.trackScheduledBlob(ctx, blob); err != nil {
+		err = fmt.Errorf("failed to schedule event: %w", err)
+		s.logger.Error(err, "event", name)
+		return "", err
+	}
+	id := ulid.Make().String()
+	if o.IdempotencyKey != "" {
+		return s.scheduleIdempotent(ctx, name, id, encodeScheduled(id, values), blob, o)
+	}
+	delay := time.Until(o.DeliverAt).Milliseconds()
+	if err := scheduleScript.Run(ctx, s.rdb, []string{s.scheduledKey}, delay, encodeScheduled(id, values)).Err(); err != nil && err != redis.Nil {
+		s.untrackBlob(ctx, blob)
+		err = fmt.Errorf("failed to schedule event: %w", err)
+		s.logger.Error(err, "event", name)
+		return "", err
+	}
+	s.logger.Info("scheduled", "event", name, "id", id, "deliver_at", o.DeliverAt)
+	return id, nil
+}
+
+// startScheduler starts the goroutine that adds the due scheduled events to
+// the stream if not already running. Each call must be matched with a call to
+// stopScheduler.
+func (s *Stream) startScheduler() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.schedulerRefs++
+	if s.schedulerRefs > 1 {
+		return
+	}
+	done := make(chan struct{})
+	s.schedulerDone = done
+	pulse.Go(context.Background(), func() { s.runScheduler(done) })
+}
+
+// stopScheduler stops the scheduler goroutine once it is no longer used.
+func (s *Stream) stopScheduler() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.schedulerRefs == 0 {
+		return
+	}
+	s.schedulerRefs--
+	if s.schedulerRefs == 0 {
+		close(s.schedulerDone)
+		s.schedulerDone = nil
+	}
+}
+
+// runScheduler periodically adds the due scheduled events to the stream until
+// done is closed. Schedulers only run while a sink or reader of the stream is
+// running. All schedulers of the stream compete for a lease so that only one
+// of them adds events at a time. The lease holder also trims the
+// stream periodically when it is configured with a maximum age or to keep
+// unacknowledged events, see trim, and deletes the offloaded payloads of the
+// trimmed events, see collectBlobs.
+func (s *Stream) runScheduler(done chan struct{}) {
+	defer s.logger.Debug("scheduler: exiting")
+	ticker := time.NewTicker(schedulerPeriod)
+	defer ticker.Stop()
+
+	owner := ulid.Make().String()
+	leaseDuration := 5 * schedulerPeriod.Milliseconds()
+	maxLen := s.addMaxLen()
+	var lastTrim time.Time
+	ctx := context.Background()
+	for {
+		select {
+		case <-ticker.C:
+			keys := []string{s.scheduledKey, s.key, s.schedulerKey, s.orderingKey, s.blobsKey}
+			moved, err := moveScheduledScript.Run(ctx, s.rdb, keys, owner, leaseDuration, maxScheduledMoved, maxLen).Int()
+			if err != nil {
+				s.logger.Error(fmt.Errorf("failed to add scheduled events: %w", err))
+				continue
+			}
+			if moved > 0 {
+				s.logger.Info("added scheduled", "events", moved)
+			}
+			if moved < 0 || time.Since(lastTrim) < trimPeriod {
+				// Only the lease holder trims the stream.
+				continue
+			}
+			lastTrim = time.Now()
+			if s.trimsPeriodically() {
+				if err := s.trim(ctx); err != nil {
+					s.logger.Error(fmt.Errorf("failed to trim stream: %w", err))
+				}
+			}
+			if s.claimCheckThreshold > 0 {
+				if err := s.collectBlobs(ctx); err != nil {
+					s.logger.Error(fmt.Errorf("failed to collect offloaded payloads: %w", err))
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// encodeScheduled encodes the scheduled event with the given ID and stream
+// entry values using the struct.pack "ic0" format.
+func encodeScheduled(id string, values []any) string {
+	var data []byte
+	appendString := func(v string) {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(v)))
+		data = append(data, v...)
+	}
+	appendString(id)
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			appendString(v)
+		case []byte:
+			appendString(string(v))
+		default:
+			appendString(fmt.Sprint(v))
+		}
+	}
+	return string(data)
+}
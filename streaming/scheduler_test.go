@@ -0,0 +1,97 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestScheduledEvents(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	var origSchedulerPeriod time.Duration
+	origSchedulerPeriod, schedulerPeriod = schedulerPeriod, testCheckIdlePeriod
+	defer func() { schedulerPeriod = origSchedulerPeriod }()
+
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+
+	// Scheduled events are not added if the stream does not exist and
+	// WithOnlyIfStreamExists is used
+	id, err := s.Add(ctx, "event", []byte("payload"), options.WithDelay(time.Minute), options.WithOnlyIfStreamExists())
+	assert.NoError(t, err)
+	assert.Empty(t, id)
+
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	c := sink.Subscribe()
+
+	// Scheduled events are delivered once due
+	start := time.Now()
+	id, err = s.Add(ctx, "later", []byte("payload"),
+		options.WithDelay(4*testCheckIdlePeriod),
+		options.WithTopic("topic"),
+		options.WithHeaders(map[string]string{"foo": "bar"}))
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+	_, err = s.Add(ctx, "now", []byte("payload"))
+	require.NoError(t, err)
+	read := readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, "now", read.EventName)
+	read = readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, "later", read.EventName)
+	assert.Equal(t, "topic", read.Topic)
+	assert.Equal(t, []byte("payload"), read.Payload)
+	assert.Equal(t, map[string]string{"foo": "bar"}, read.Headers)
+	assert.NotEqual(t, id, read.ID)
+	assert.GreaterOrEqual(t, time.Since(start), 4*testCheckIdlePeriod)
+	n, err := rdb.ZCard(ctx, s.scheduledKey).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	// Events scheduled in the past are added right away
+	id, err = s.Add(ctx, "past", []byte("payload"), options.WithDeliverAt(time.Now().Add(-time.Minute)))
+	require.NoError(t, err)
+	read = readOneEvent(t, ctx, c, sink)
+	assert.Equal(t, id, read.ID)
+}
+
+func TestScheduleUsesRedisTime(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Destroy(ctx)) }()
+
+	// Scheduled events are due relative to the Redis clock
+	_, err = s.Add(ctx, "event", []byte("payload"), options.WithDelay(time.Hour))
+	require.NoError(t, err)
+	now, err := rdb.Time(ctx).Result()
+	require.NoError(t, err)
+	scheduled, err := rdb.ZRangeWithScores(ctx, s.scheduledKey, 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, scheduled, 1)
+	assert.InDelta(t, now.Add(time.Hour).UnixMilli(), scheduled[0].Score, float64(time.Second.Milliseconds()))
+}
+
+func TestEncodeScheduled(t *testing.T) {
+	got := encodeScheduled("id", []any{nameKey, "name", payloadKey, []byte{0, 1}})
+	want := "\x02\x00\x00\x00id" +
+		"\x01\x00\x00\x00n\x04\x00\x00\x00name" +
+		"\x01\x00\x00\x00p\x02\x00\x00\x00\x00\x01"
+	assert.Equal(t, want, got)
+}
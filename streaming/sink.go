@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -58,6 +57,12 @@ type (
 		lock sync.Mutex
 		// streams are the streams the sink consumes events from.
 		streams []*Stream
+		// streamsSnapshot is a copy of streams used by Stats and Pending
+		// so that they do not wait for lock, which the read loop holds
+		// while sending events to subscribers.
+		streamsSnapshot []*Stream
+		// streamsLock protects streamsSnapshot.
+		streamsLock sync.RWMutex
 		// streamCursors is the stream cursors used to read events in
 		// the form [stream1, ">", stream2, ">", ..."]
 		streamCursors []string
@@ -68,8 +73,10 @@ type (
 		maxPolled int64
 		// bufferSize is the sink channel buffer size.
 		bufferSize int
-		// chans are the sink event channels.
-		chans []chan *Event
+		// subs are the sink subscriptions.
+		subs []*subscription
+		// index indexes subs so that Unsubscribe does not wait for lock.
+		index subscriptionIndex
 		// donechan is the sink done channel.
 		donechan chan struct{}
 		// wait is the sink cleanup wait group.
@@ -91,12 +98,32 @@ type (
 		ackGracePeriod time.Duration
 		// lastKeepAlive is the last keep-alive timestamp for this consumer.
 		lastKeepAlive int64
+		// maxDeliveries is the maximum number of deliveries of an event
+		// before it is moved to the dead-letter stream, 0 if unlimited.
+		maxDeliveries int64
+		// deadLetter is the dead-letter stream, nil if maxDeliveries is 0.
+		deadLetter *Stream
+		// errorsKey is the key of the hash that stores the last error
+		// recorded for each event.
+		errorsKey string
+		// retriesKey is the key of the sorted set that stores the
+		// redelivery deadlines of negatively acknowledged events.
+		retriesKey string
+		// retryInitial is the delay of the first redelivery.
+		retryInitial time.Duration
+		// retryMax is the maximum redelivery delay, 0 if unlimited.
+		retryMax time.Duration
+		// retryFactor is the redelivery delay multiplier.
+		retryFactor float64
+		// retryJitter is the fraction of the redelivery delay randomly
+		// added or subtracted.
+		retryJitter float64
 		// logger is the logger used by the sink.
 		logger pulse.Logger
 		// acquireLease is the acquire lease script.
 		acquireLease *redis.Script
 		// rdb is the redis connection.
-		rdb *redis.Client
+		rdb redis.UniversalClient
 	}
 
 	// eventFilterFunc is the function used to filter events.
@@ -110,13 +137,7 @@ type (
 // which is not the semantics Pulse wants to enforce.
 func newSink(ctx context.Context, name string, stream *Stream, opts ...options.Sink) (*Sink, error) {
 	o := options.ParseSinkOptions(opts...)
-	var eventMatcher eventFilterFunc
-	if o.Topic != "" {
-		eventMatcher = func(e *Event) bool { return e.Topic == o.Topic }
-	} else if o.TopicPattern != "" {
-		topicPatternRegexp := regexp.MustCompile(o.TopicPattern)
-		eventMatcher = func(e *Event) bool { return topicPatternRegexp.MatchString(e.Topic) }
-	}
+	eventMatcher := newEventFilter(o.Topic, o.TopicPattern, o.Headers)
 
 	if err := acquireLeaseScript.Load(ctx, stream.rdb).Err(); err != nil {
 		return nil, fmt.Errorf("failed to load stale check lease script: %w", err)
@@ -136,12 +157,25 @@ func newSink(ctx context.Context, name string, stream *Stream, opts ...options.S
 		return nil, fmt.Errorf("failed to create Redis consumer group %s for stream %s: %w", name, stream.Name, err)
 	}
 
+	var deadLetter *Stream
+	if o.MaxDeliveries > 0 {
+		dlName := o.DeadLetterStream
+		if dlName == "" {
+			dlName = name + ":dlq"
+		}
+		deadLetter, err = NewStream(dlName, stream.rdb, options.WithStreamLogger(stream.rootLogger))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter stream for sink %s: %w", name, err)
+		}
+	}
+
 	sink := &Sink{
 		Name:                  name,
 		leaseKeyName:          []string{staleLockName(name)},
 		startID:               o.LastEventID,
 		noAck:                 o.NoAck,
 		streams:               []*Stream{stream},
+		streamsSnapshot:       []*Stream{stream},
 		streamCursors:         []string{stream.key, ">"},
 		blockDuration:         o.BlockDuration,
 		maxPolled:             o.MaxPolled,
@@ -151,6 +185,14 @@ func newSink(ctx context.Context, name string, stream *Stream, opts ...options.S
 		consumersMap:          map[string]*rmap.Map{stream.Name: cm},
 		consumersKeepAliveMap: km,
 		ackGracePeriod:        o.AckGracePeriod,
+		maxDeliveries:         o.MaxDeliveries,
+		deadLetter:            deadLetter,
+		errorsKey:             sinkErrorsKey(name),
+		retriesKey:            sinkRetriesKey(name),
+		retryInitial:          o.RetryInitial,
+		retryMax:              o.RetryMax,
+		retryFactor:           o.RetryFactor,
+		retryJitter:           o.RetryJitter,
 		acquireLease:          acquireLeaseScript,
 		logger:                logger,
 		rdb:                   stream.rdb,
@@ -162,38 +204,48 @@ func newSink(ctx context.Context, name string, stream *Stream, opts ...options.S
 	sink.consumer = consumer
 	sink.logger = sink.logger.WithPrefix("consumer", consumer)
 
+	stream.startScheduler()
 	sink.wait.Add(3)
 	pulse.Go(ctx, func() { sink.read(ctx) })
 	pulse.Go(ctx, sink.periodicKeepAlive)
 	pulse.Go(ctx, sink.periodicIdleMessageCheck)
 
-	sink.logger.Info("created", "start", sink.startID, "stream", stream.Name, "max_polled", sink.maxPolled, "block_duration", sink.blockDuration, "buffer_size", sink.bufferSize, "no_ack", sink.noAck, "ack_grace_period", sink.ackGracePeriod)
+	sink.logger.Info("created", "start", sink.startID, "stream", stream.Name, "max_polled", sink.maxPolled, "block_duration", sink.blockDuration, "buffer_size", sink.bufferSize, "no_ack", sink.noAck, "ack_grace_period", sink.ackGracePeriod, "max_deliveries", sink.maxDeliveries)
 
 	return sink, nil
 }
 
-// Subscribe returns a channel that receives events from the sink.
-func (s *Sink) Subscribe() <-chan *Event {
-	c := make(chan *Event, s.bufferSize)
+// Subscribe returns a channel that receives events from the sink. The channel
+// is closed when the sink is closed or, if the OverflowFail policy is used,
+// when the channel buffer is full.
+func (s *Sink) Subscribe(opts ...options.Subscribe) <-chan *Event {
+	sub := newSubscription(s.bufferSize, opts...)
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.chans = append(s.chans, c)
-	return c
+	s.subs = append(s.subs, sub)
+	s.index.add(sub)
+	return sub.c
 }
 
 // Unsubscribe removes the channel from the sink and closes it.
 func (s *Sink) Unsubscribe(c <-chan *Event) {
+	s.index.cancel(c) // Interrupt any send blocked on c
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	for i, ch := range s.chans {
-		if ch == c {
-			close(ch)
-			s.chans = append(s.chans[:i], s.chans[i+1:]...)
-			return
-		}
+	if i := findSubscription(s.subs, c); i >= 0 {
+		s.subs[i].close()
+		s.subs = append(s.subs[:i], s.subs[i+1:]...)
 	}
 }
 
+// Dropped returns the number of events dropped by the subscription with
+// channel c because its buffer was full, see WithSubscribeOverflow.
+func (s *Sink) Dropped(c <-chan *Event) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return dropped(s.subs, c)
+}
+
 // Ack acknowledges the event.
 func (s *Sink) Ack(ctx context.Context, e *Event) error {
 	err := e.Acker.XAck(ctx, e.streamKey, e.SinkName, e.ID).Err()
@@ -201,6 +253,11 @@ func (s *Sink) Ack(ctx context.Context, e *Event) error {
 		s.logger.Error(err, "ack", e.ID, "stream", e.StreamName)
 		return err
 	}
+	if s.maxDeliveries > 0 {
+		if err := s.rdb.HDel(ctx, s.errorsKey, eventField(e.StreamName, e.ID)).Err(); err != nil {
+			s.logger.Error(fmt.Errorf("failed to delete last error: %w", err), "ack", e.ID, "stream", e.StreamName)
+		}
+	}
 	s.logger.Debug("acked", "event", e.ID, "stream", e.StreamName, "from-sink", e.SinkName)
 	return nil
 }
@@ -208,6 +265,8 @@ func (s *Sink) Ack(ctx context.Context, e *Event) error {
 // AddStream adds the stream to the sink. By default the stream cursor starts at
 // the same timestamp as the sink main stream cursor.  This can be overridden
 // with opts. AddStream does nothing if the stream is already part of the sink.
+// When using Redis Cluster the stream name must share the same hash tag as the
+// other streams of the sink, see NewStream.
 func (s *Sink) AddStream(ctx context.Context, stream *Stream, opts ...options.AddStream) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -236,6 +295,8 @@ func (s *Sink) AddStream(ctx context.Context, stream *Stream, opts ...options.Ad
 		return fmt.Errorf("failed to create Redis consumer group %s for stream %s: %w", s.Name, stream.Name, err)
 	}
 	s.streams = append(s.streams, stream)
+	s.snapshotStreams()
+	stream.startScheduler()
 	s.streamCursors = make([]string, len(s.streams)*2)
 	for i, stream := range s.streams {
 		s.streamCursors[i] = stream.key
@@ -254,6 +315,8 @@ func (s *Sink) RemoveStream(ctx context.Context, stream *Stream) error {
 	for i, st := range s.streams {
 		if st == stream {
 			s.streams = append(s.streams[:i], s.streams[i+1:]...)
+			s.snapshotStreams()
+			stream.stopScheduler()
 			found = true
 			break
 		}
@@ -301,11 +364,16 @@ func (s *Sink) Close(ctx context.Context) {
 	s.wait.Wait()
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	for _, c := range s.chans {
-		close(c)
+	for _, sub := range s.subs {
+		sub.close()
 	}
+	s.subs = nil
+	s.index.cancelAll()
 	// Note: we do not delete the consumer from the keep-alive and consumer maps
 	// so that another instance may claim any pending messages.
+	for _, stream := range s.streams {
+		stream.stopScheduler()
+	}
 	s.consumersKeepAliveMap.Close()
 	for _, cm := range s.consumersMap {
 		cm.Close()
@@ -356,10 +424,12 @@ func (s *Sink) read(ctx context.Context) {
 		s.lock.Lock()
 		readStreams := make([]string, len(s.streamCursors))
 		copy(readStreams, s.streamCursors)
+		streams := make([]*Stream, len(s.streams))
+		copy(streams, s.streams)
 		s.lock.Unlock()
 
 		s.logger.Debug("reading", "streams", readStreams, "max", s.maxPolled, "block", s.blockDuration)
-		streams, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		read, readErr := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    s.Name,
 			Consumer: s.consumer,
 			Streams:  readStreams,
@@ -368,6 +438,17 @@ func (s *Sink) read(ctx context.Context) {
 			NoAck:    s.noAck,
 		}).Result()
 
+		// Load the events before taking the lock as payloads may have to be
+		// fetched from the blob store.
+		loaded := make([][]*Event, len(read))
+		for i, events := range read {
+			stream := streamByKey(streams, events.Stream)
+			if stream == nil {
+				continue
+			}
+			loaded[i] = s.loadEvents(ctx, stream, events.Messages)
+		}
+
 		s.lock.Lock()
 		if s.closing {
 			s.lock.Unlock()
@@ -375,16 +456,20 @@ func (s *Sink) read(ctx context.Context) {
 			// Any events in the PEL will be claimed by another consumer.
 			return
 		}
-		if err != nil {
-			if err := handleReadError(err, s.logger); err != nil {
+		if readErr != nil {
+			if err := handleReadError(readErr, s.logger); err != nil {
 				s.logger.Error(fmt.Errorf("error reading events: %w", err))
 			}
 			s.lock.Unlock()
 			continue
 		}
-		for _, events := range streams {
-			streamName := events.Stream[len(streamKeyPrefix):]
-			streamEvents(streamName, events.Stream, s.Name, events.Messages, s.eventFilter, s.chans, s.rdb, s.logger)
+		for i, events := range read {
+			stream := streamByKey(s.streams, events.Stream)
+			if stream == nil {
+				// Stream was removed while reading.
+				continue
+			}
+			streamEvents(stream, loaded[i], s.subs, nil, s.logger)
 		}
 		s.lock.Unlock()
 	}
@@ -449,7 +534,7 @@ func (s *Sink) periodicIdleMessageCheck() {
 		case <-ticker.C:
 			now := time.Now().UnixNano() / int64(time.Millisecond)
 			newExpiration := now + leaseDuration
-			result, err := s.acquireLease.EvalSha(ctx, s.rdb, s.leaseKeyName, newExpiration, now, leaseDuration).Result()
+			result, err := s.acquireLease.Run(ctx, s.rdb, s.leaseKeyName, newExpiration, now, leaseDuration).Result()
 			if err != nil {
 				s.logger.Error(fmt.Errorf("failed to acquire idle message check lease: %v", err))
 				continue
@@ -472,7 +557,17 @@ func (s *Sink) periodicIdleMessageCheck() {
 // claimIdleMessages claims idle messages from the streams.
 // s.lock must be held.
 func (s *Sink) claimIdleMessages(ctx context.Context) {
+	if !s.noAck {
+		if err := s.retryNackedMessages(ctx); err != nil {
+			s.logger.Error(fmt.Errorf("failed to redeliver nacked messages: %w", err))
+		}
+	}
 	for _, stream := range s.streams {
+		if s.maxDeliveries > 0 {
+			if err := s.deadLetterIdleMessages(ctx, stream); err != nil {
+				s.logger.Error(fmt.Errorf("failed to dead-letter idle messages for stream %s: %w", stream.Name, err))
+			}
+		}
 		args := redis.XAutoClaimArgs{
 			Stream:   stream.key,
 			Group:    s.Name,
@@ -480,14 +575,14 @@ func (s *Sink) claimIdleMessages(ctx context.Context) {
 			Start:    "0-0",
 			Consumer: s.consumer,
 		}
-		start, err := s.claim(ctx, stream.Name, args)
+		start, err := s.claim(ctx, stream, args)
 		if err != nil {
 			s.logger.Error(fmt.Errorf("failed to claim idle messages for stream %s: %w", stream.Name, err))
 			continue
 		}
 		for start != "0-0" {
 			args.Start = start
-			start, err = s.claim(ctx, stream.Name, args)
+			start, err = s.claim(ctx, stream, args)
 			if err != nil {
 				s.logger.Error(fmt.Errorf("failed to claim idle messages for stream %s: %w", stream.Name, err))
 				break
@@ -545,15 +640,38 @@ func (s *Sink) deleteStaleConsumers(ctx context.Context) {
 }
 
 // Helper function to claim messages from a stream used by claimIdleMessages.
-func (s *Sink) claim(ctx context.Context, streamName string, args redis.XAutoClaimArgs) (string, error) {
+func (s *Sink) claim(ctx context.Context, stream *Stream, args redis.XAutoClaimArgs) (string, error) {
 	messages, start, err := s.rdb.XAutoClaim(ctx, &args).Result()
 	if len(messages) > 0 {
-		s.logger.Info("claimed", "stream", streamName, "messages", len(messages))
-		streamEvents(streamName, args.Stream, s.Name, messages, s.eventFilter, s.chans, s.rdb, s.logger)
+		s.logger.Info("claimed", "stream", stream.Name, "messages", len(messages))
+		s.deliver(ctx, stream, messages)
 	}
 	return start, err
 }
 
+// deliver loads and streams the given messages to the sink subscriptions.
+// s.lock must be held.
+func (s *Sink) deliver(ctx context.Context, stream *Stream, msgs []redis.XMessage) {
+	streamEvents(stream, s.loadEvents(ctx, stream, msgs), s.subs, nil, s.logger)
+}
+
+// loadEvents returns the events corresponding to the given messages, see
+// loadEvents. Messages whose payload cannot be loaded are skipped: they stay
+// pending and are claimed again once idle, see claimIdleMessages.
+func (s *Sink) loadEvents(ctx context.Context, stream *Stream, msgs []redis.XMessage) []*Event {
+	var events []*Event
+	for len(msgs) > 0 {
+		loaded, n, err := loadEvents(ctx, stream, s, msgs, s.eventFilter, s.rdb, s.logger)
+		events = append(events, loaded...)
+		if err == nil {
+			break
+		}
+		s.logger.Error(err, "stream", stream.Name)
+		msgs = msgs[n+1:]
+	}
+	return events
+}
+
 // deleteConsumerGroup deletes the consumer group.
 func (s *Sink) deleteConsumerGroup(ctx context.Context, stream *Stream) error {
 	if err := s.rdb.XGroupDestroy(ctx, stream.key, s.Name).Err(); err != nil {
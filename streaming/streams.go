@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	redis "github.com/redis/go-redis/v9"
 	"goa.design/pulse/pulse"
@@ -21,14 +25,48 @@ type (
 		Name string
 		// MaxLen is the maximum number of events in the stream.
 		MaxLen int
+		// MaxAge is the maximum age of the events in the stream, 0 if
+		// unlimited.
+		MaxAge time.Duration
+		// keepUnacked is true if events not acknowledged by all the
+		// stream consumer groups must not be trimmed.
+		keepUnacked bool
+		// idempotencyWindow is the duration during which idempotency keys
+		// are remembered.
+		idempotencyWindow time.Duration
+		// compressor compresses the event payloads if not nil.
+		compressor pulse.Compressor
+		// claimCheckThreshold is the size above which payloads are
+		// offloaded to the blob store, 0 if payloads are never offloaded.
+		claimCheckThreshold int
+		// blobStore stores the offloaded payloads.
+		blobStore options.BlobStore
+		// blobsKey is the key of the sorted set that tracks the offloaded
+		// payloads indexed by event timestamp.
+		blobsKey string
 		// logger is the logger used by the stream.
 		logger pulse.Logger
 		// rootLogger is the prefix-free logger used to create sink loggers.
 		rootLogger pulse.Logger
 		// key is the redis key used for the stream.
 		key string
+		// scheduledKey is the key of the sorted set that stores the
+		// events scheduled for later delivery.
+		scheduledKey string
+		// schedulerKey is the key used to store the scheduler lease.
+		schedulerKey string
+		// orderingKey is the key of the hash that stores the ID of the
+		// last event added with each ordering key.
+		orderingKey string
+		// lock protects the scheduler fields.
+		lock sync.Mutex
+		// schedulerRefs is the number of sinks and readers using the
+		// scheduler.
+		schedulerRefs int
+		// schedulerDone is closed to stop the scheduler.
+		schedulerDone chan struct{}
 		// rdb is the redis connection.
-		rdb *redis.Client
+		rdb redis.UniversalClient
 	}
 )
 
@@ -41,15 +79,102 @@ const (
 	payloadKey = "p"
 	// topicKey is the key used to store the event topic.
 	topicKey = "t"
+	// orderingKeyKey is the key used to store the event ordering key.
+	orderingKeyKey = "k"
+	// prevKeyedKey is the key used to store the ID of the previous event
+	// added with the same ordering key.
+	prevKeyedKey = "kp"
+	// schemaVersionKey is the key used to store the event payload schema
+	// version.
+	schemaVersionKey = "v"
+	// compressionKey is the key used to store the name of the compressor
+	// used to compress the event payload.
+	compressionKey = "c"
+	// headerKeyPrefix is the prefix of the keys used to store the event
+	// headers.
+	headerKeyPrefix = "h:"
 )
 
+// addScript is the script used to add events with an idempotency key or an
+// ordering key. KEYS[3] is the idempotency key if any. If the idempotency key
+// is still recorded then the script returns the ID of the event previously
+// added with the same key. Otherwise it adds the event, records its ID under
+// the idempotency key for the duration of the idempotency window and returns
+// the new ID. Events with an ordering key record the ID of the previous event
+// added with the same key, see Sink.isNextForKey. The second element of the
+// result is 1 if the event was added and 0 otherwise. The script returns nil
+// if the stream does not exist and the event must only be added if it does.
+var addScript = redis.NewScript(`
+    local stream = KEYS[1]
+    local ordering = KEYS[2]
+    local key = KEYS[3]
+    local window = ARGV[1]
+    local maxlen = tonumber(ARGV[2])
+    local minid = ARGV[3]
+    local mustExist = ARGV[4] == "1"
+    local orderingKey = ARGV[5]
+
+    if key then
+        local id = redis.call("GET", key)
+        if id then
+            return {id, 0}
+        end
+    end
+    if mustExist and redis.call("EXISTS", stream) == 0 then
+        return nil
+    end
+
+    local args = {stream}
+    if maxlen > 0 then
+        table.insert(args, "MAXLEN")
+        table.insert(args, "~")
+        table.insert(args, maxlen)
+    end
+    table.insert(args, "*")
+    for i = 6, #ARGV do
+        table.insert(args, ARGV[i])
+    end
+    if orderingKey ~= "" then
+        local prev = redis.call("HGET", ordering, orderingKey)
+        if prev then
+            table.insert(args, "kp")
+            table.insert(args, prev)
+        end
+    end
+    local id = redis.call("XADD", unpack(args))
+    if orderingKey ~= "" then
+        redis.call("HSET", ordering, orderingKey, id)
+    end
+    if minid ~= "" then
+        redis.call("XTRIM", stream, "MINID", "~", minid)
+    end
+    if key then
+        redis.call("SET", key, id, "PX", window)
+    end
+    return {id, 1}
+`)
+
 // NewStream returns the stream with the given name. All stream instances
-// with the same name share the same events.
-func NewStream(name string, rdb *redis.Client, opts ...options.Stream) (*Stream, error) {
+// with the same name share the same events. rdb may be any Redis client
+// including cluster, failover (Sentinel) and ring clients. Sinks and readers
+// that consume multiple streams read them with a single Redis command so when
+// using Redis Cluster the stream names must share the same hash tag, for
+// example "{orders}.created" and "{orders}.shipped". Names that contain a
+// closing curly brace must include a non-empty hash tag.
+func NewStream(name string, rdb redis.UniversalClient, opts ...options.Stream) (*Stream, error) {
 	if !isValidRedisKeyName(name) {
 		return nil, fmt.Errorf("pulse stream: not a valid name %q", name)
 	}
+	if strings.IndexByte(name, '}') >= 0 && !hasHashTag(name) {
+		return nil, fmt.Errorf("pulse stream: name %q contains '}' but no hash tag", name)
+	}
 	o := options.ParseStreamOptions(opts...)
+	if o.IdempotencyWindow <= 0 {
+		return nil, fmt.Errorf("pulse stream: idempotency window must be positive, got %v", o.IdempotencyWindow)
+	}
+	if o.Compressor != nil {
+		pulse.RegisterCompressor(o.Compressor)
+	}
 	var logger pulse.Logger
 	if o.Logger != nil {
 		logger = o.Logger.WithPrefix("stream", name)
@@ -57,12 +182,25 @@ func NewStream(name string, rdb *redis.Client, opts ...options.Stream) (*Stream,
 		logger = pulse.NoopLogger()
 	}
 	s := &Stream{
-		Name:       name,
-		MaxLen:     o.MaxLen,
-		logger:     logger,
-		rootLogger: o.Logger,
-		key:        streamKeyPrefix + name,
-		rdb:        rdb,
+		Name:                name,
+		MaxLen:              o.MaxLen,
+		MaxAge:              o.MaxAge,
+		keepUnacked:         o.KeepUnacked,
+		idempotencyWindow:   o.IdempotencyWindow,
+		compressor:          o.Compressor,
+		claimCheckThreshold: o.ClaimCheckThreshold,
+		blobStore:           o.BlobStore,
+		blobsKey:            sameSlotKey(streamKeyPrefix+name, ":blobs"),
+		logger:              logger,
+		rootLogger:          o.Logger,
+		key:                 streamKeyPrefix + name,
+		scheduledKey:        sameSlotKey(streamKeyPrefix+name, ":scheduled"),
+		schedulerKey:        sameSlotKey(streamKeyPrefix+name, ":scheduler"),
+		orderingKey:         sameSlotKey(streamKeyPrefix+name, ":ordering"),
+		rdb:                 rdb,
+	}
+	if s.claimCheckThreshold > 0 && s.blobStore == nil {
+		s.blobStore = NewRedisBlobStore(rdb)
 	}
 	return s, nil
 }
@@ -110,36 +248,125 @@ func (s *Stream) NewSink(ctx context.Context, name string, opts ...options.Sink)
 // Add appends an event to the stream and returns its ID. If the option
 // WithOnlyIfStreamExists is used and the stream does not exist then no event is
 // added and the empty string is returned. The stream is created if the option
-// is omitted or when NewSink is called.
+// is omitted or when NewSink is called. If the option WithDeliverAt or
+// WithDelay is used and the delivery time is in the future then the event is
+// scheduled instead: it is stored durably in Redis and added to the stream by
+// the stream sinks and readers once due. Add then returns an ID that identifies
+// the scheduled event and differs from the ID the event gets once added to the
+// stream. If the option WithIdempotencyKey is used and an event with the same
+// key was added within the stream idempotency window then no event is added and
+// the ID of the original event is returned.
 func (s *Stream) Add(ctx context.Context, name string, payload []byte, opts ...options.AddEvent) (string, error) {
 	o := options.ParseAddEventOptions(opts...)
 	for _, option := range opts {
 		option(&o)
 	}
-	values := []any{nameKey, name, payloadKey, payload}
+	values := []any{nameKey, name}
+	if s.compressor != nil {
+		compressed, err := s.compressor.Compress(payload)
+		if err != nil {
+			err = fmt.Errorf("failed to compress event: %w", err)
+			s.logger.Error(err, "event", name)
+			return "", err
+		}
+		values = append(values, payloadKey, compressed, compressionKey, s.compressor.Name())
+	} else {
+		values = append(values, payloadKey, payload)
+	}
 	if o.Topic != "" {
 		values = append(values, topicKey, o.Topic)
 	}
-	res, err := s.rdb.XAdd(ctx, &redis.XAddArgs{
+	if o.OrderingKey != "" {
+		values = append(values, orderingKeyKey, o.OrderingKey)
+	}
+	if o.SchemaVersion != 0 {
+		values = append(values, schemaVersionKey, strconv.Itoa(o.SchemaVersion))
+	}
+	values = appendHeaders(values, o.Headers)
+	if time.Until(o.DeliverAt) > 0 {
+		return s.schedule(ctx, name, values, o)
+	}
+	values, blob, err := s.offload(ctx, values)
+	if err != nil {
+		err = fmt.Errorf("failed to add event: %w", err)
+		s.logger.Error(err, "event", name)
+		return "", err
+	}
+	if o.IdempotencyKey != "" || o.OrderingKey != "" {
+		return s.addAtomic(ctx, name, values, blob, o)
+	}
+	args := &redis.XAddArgs{
 		Stream:     s.key,
 		Values:     values,
-		MaxLen:     int64(s.MaxLen),
-		Approx:     true,
 		NoMkStream: o.OnlyIfStreamExists,
-	}).Result()
-	if err != nil {
-		if err == redis.Nil {
-			// Stream does not exist and OnlyIfStreamExists option was used.
-			return "", nil
+		MaxLen:     int64(s.addMaxLen()),
+		Approx:     true,
+	}
+	var add *redis.StringCmd
+	_, err = s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		add = pipe.XAdd(ctx, args)
+		if s.MaxAge > 0 && !s.keepUnacked {
+			pipe.XTrimMinIDApprox(ctx, s.key, s.maxAgeMinID(), 0)
 		}
+		return nil
+	})
+	res, addErr := add.Result()
+	if addErr == redis.Nil {
+		// Stream does not exist and OnlyIfStreamExists option was used.
+		s.trackBlob(ctx, blob, "")
+		return "", nil
+	}
+	if err != nil {
+		s.trackBlob(ctx, blob, "")
 		err = fmt.Errorf("failed to add event: %w", err)
 		s.logger.Error(err, "event", name)
 		return "", err
 	}
+	s.trackBlob(ctx, blob, res)
 	s.logger.Info("add", "event", name, "id", res)
 	return res, nil
 }
 
+// addAtomic adds the event with the given values to the stream using addScript,
+// see Add. blob is the key of the offloaded event payload if any.
+func (s *Stream) addAtomic(ctx context.Context, name string, values []any, blob string, o options.AddEventOptions) (string, error) {
+	maxLen := s.addMaxLen()
+	var minID string
+	if !s.keepUnacked && s.MaxAge > 0 {
+		minID = s.maxAgeMinID()
+	}
+	mustExist := "0"
+	if o.OnlyIfStreamExists {
+		mustExist = "1"
+	}
+	keys := []string{s.key, s.orderingKey}
+	if o.IdempotencyKey != "" {
+		keys = append(keys, s.idempotencyKey(o.IdempotencyKey))
+	}
+	args := append([]any{s.idempotencyWindow.Milliseconds(), maxLen, minID, mustExist, o.OrderingKey}, values...)
+	res, err := addScript.Run(ctx, s.rdb, keys, args...).Slice()
+	if err == redis.Nil {
+		// Stream does not exist and OnlyIfStreamExists option was used.
+		s.trackBlob(ctx, blob, "")
+		return "", nil
+	}
+	if err != nil {
+		s.trackBlob(ctx, blob, "")
+		err = fmt.Errorf("failed to add event: %w", err)
+		s.logger.Error(err, "event", name)
+		return "", err
+	}
+	id := res[0].(string)
+	if res[1].(int64) == 0 {
+		s.trackBlob(ctx, blob, "")
+		s.logger.Info("duplicate", "event", name, "id", id, "idempotency-key", o.IdempotencyKey)
+		return id, nil
+	}
+	s.trackBlob(ctx, blob, id)
+	s.logger.Info("add", "event", name, "id", id)
+	return id, nil
+}
+
 // Remove removes the events with the given IDs from the stream.
 // Note: clients should not need to call this method in normal operation,
 // instead they should use the Ack method to acknowledge events.
@@ -154,9 +381,25 @@ func (s *Stream) Remove(ctx context.Context, ids ...string) error {
 	return nil
 }
 
-// Destroy deletes the entire stream and all its messages.
+// Destroy deletes the entire stream and all its messages including the
+// scheduled ones and the payloads offloaded to the stream blob store.
 func (s *Stream) Destroy(ctx context.Context) error {
-	if err := s.rdb.Del(ctx, s.key).Err(); err != nil {
+	if s.blobStore != nil {
+		if err := s.deleteBlobs(ctx); err != nil {
+			err := fmt.Errorf("failed to destroy stream: %w", err)
+			s.logger.Error(err)
+			return err
+		}
+	}
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.key)
+		pipe.Del(ctx, s.scheduledKey)
+		pipe.Del(ctx, s.schedulerKey)
+		pipe.Del(ctx, s.blobsKey)
+		pipe.Del(ctx, s.orderingKey)
+		return nil
+	})
+	if err != nil {
 		err := fmt.Errorf("failed to destroy stream: %w", err)
 		s.logger.Error(err)
 		return err
@@ -165,6 +408,47 @@ func (s *Stream) Destroy(ctx context.Context) error {
 	return nil
 }
 
+// decodePayload returns the payload of the given stream entry, decompressed if
+// needed.
+func decodePayload(values map[string]any) ([]byte, error) {
+	var payload []byte
+	if p, ok := values[payloadKey]; ok {
+		payload = []byte(p.(string))
+	}
+	name, ok := values[compressionKey]
+	if !ok {
+		return payload, nil
+	}
+	c, ok := pulse.LookupCompressor(name.(string))
+	if !ok {
+		return nil, fmt.Errorf("unknown compressor %q", name)
+	}
+	return c.Decompress(payload)
+}
+
+// sameSlotKey returns the key made of the given stream key and suffix, hash
+// tagged so that it belongs to the same Redis Cluster slot as the stream. The
+// stream key hash tag is reused if it has one, otherwise the whole stream key
+// becomes the hash tag. The stream key may only contain a closing curly brace
+// if it has a hash tag, see NewStream.
+func sameSlotKey(streamKey, suffix string) string {
+	if hasHashTag(streamKey) {
+		return streamKey + suffix
+	}
+	return "{" + streamKey + "}" + suffix
+}
+
+// hasHashTag returns true if Redis Cluster only hashes part of the given key
+// to compute its slot, i.e. if the key contains a non-empty substring
+// enclosed in curly braces.
+func hasHashTag(key string) bool {
+	i := strings.IndexByte(key, '{')
+	if i < 0 {
+		return false
+	}
+	return strings.IndexByte(key[i+1:], '}') > 0
+}
+
 // redisKeyRegex is a regular expression that matches valid Redis keys.
 var redisKeyRegex = regexp.MustCompile(`^[^ \0\*\?\[\]]{1,512}$`)
 
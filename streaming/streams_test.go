@@ -1,9 +1,12 @@
 package streaming
 
 import (
+	"compress/gzip"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"goa.design/pulse/pulse"
 	"goa.design/pulse/streaming/options"
@@ -37,6 +40,25 @@ func TestOptions(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 10, s.MaxLen)
 	assert.Equal(t, pulse.NoopLogger(), s.logger)
+	_, err = NewStream("{}orders", nil)
+	assert.Error(t, err, "closing brace without hash tag")
+}
+
+func TestSameSlotKey(t *testing.T) {
+	cases := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"no hash tag", "pulse:stream:orders", "{pulse:stream:orders}:scheduled"},
+		{"hash tag", "pulse:stream:{orders}.created", "pulse:stream:{orders}.created:scheduled"},
+		{"unclosed brace", "pulse:stream:{orders", "{pulse:stream:{orders}:scheduled"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, sameSlotKey(c.key, ":scheduled"))
+		})
+	}
 }
 
 func TestAdd(t *testing.T) {
@@ -107,3 +129,107 @@ func TestTopic(t *testing.T) {
 
 	assert.NoError(t, s.Destroy(ctx))
 }
+
+func TestHeaders(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	reader, err := s.NewReader(ctx, options.WithReaderStartAtOldest(), options.WithReaderBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupReader(t, ctx, s, reader)
+	sink, err := s.NewSink(ctx, "sink",
+		options.WithSinkStartAtOldest(),
+		options.WithSinkBlockDuration(testBlockDuration),
+		options.WithSinkHeader("tenant", "acme"))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	rc := reader.Subscribe()
+	sc := sink.Subscribe()
+
+	headers := map[string]string{"tenant": "acme", "content-type": "application/json"}
+	_, err = s.Add(ctx, "other", []byte("payload"), options.WithHeaders(map[string]string{"tenant": "other"}))
+	require.NoError(t, err)
+	id, err := s.Add(ctx, "event", []byte("payload"), options.WithHeaders(headers))
+	require.NoError(t, err)
+
+	// Reader receives all events along with their headers
+	read := readOneReaderEvent(t, rc)
+	assert.Equal(t, map[string]string{"tenant": "other"}, read.Headers)
+	read = readOneReaderEvent(t, rc)
+	assert.Equal(t, headers, read.Headers)
+
+	// Sink only receives events matching the header filter
+	read = readOneEvent(t, ctx, sc, sink)
+	assert.Equal(t, id, read.ID)
+	assert.Equal(t, headers, read.Headers)
+}
+
+func TestCompression(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb,
+		options.WithStreamCompression(pulse.GzipCompressor(gzip.BestSpeed)),
+		options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink", options.WithSinkStartAtOldest(), options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s, sink)
+	// Readers decompress events regardless of the stream options
+	plain, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	reader, err := plain.NewReader(ctx, options.WithReaderStartAtOldest(), options.WithReaderBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupReader(t, ctx, plain, reader)
+	sc := sink.Subscribe()
+	rc := reader.Subscribe()
+
+	payload := []byte(strings.Repeat(`{"temperature":42}`, 100))
+	_, err = s.Add(ctx, "event", payload)
+	require.NoError(t, err)
+
+	// Payload is stored compressed
+	msgs, err := rdb.XRange(ctx, s.key, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "gzip", msgs[0].Values[compressionKey])
+	assert.Less(t, len(msgs[0].Values[payloadKey].(string)), len(payload))
+
+	read := readOneEvent(t, ctx, sc, sink)
+	assert.Equal(t, payload, read.Payload)
+	read = readOneReaderEvent(t, rc)
+	assert.Equal(t, payload, read.Payload)
+}
+
+func TestEventFilter(t *testing.T) {
+	ev := &Event{Topic: "foo", Headers: map[string]string{"a": "1", "b": "2"}}
+	cases := []struct {
+		name    string
+		topic   string
+		pattern string
+		headers map[string]string
+		want    bool
+	}{
+		{"topic", "foo", "", nil, true},
+		{"other topic", "bar", "", nil, false},
+		{"pattern", "", "f.*", nil, true},
+		{"header", "", "", map[string]string{"a": "1"}, true},
+		{"headers", "", "", map[string]string{"a": "1", "b": "2"}, true},
+		{"other header value", "", "", map[string]string{"a": "2"}, false},
+		{"missing header", "", "", map[string]string{"c": ""}, false},
+		{"topic and header", "foo", "", map[string]string{"a": "1"}, true},
+		{"other topic and header", "bar", "", map[string]string{"a": "1"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filter := newEventFilter(c.topic, c.pattern, c.headers)
+			require.NotNil(t, filter)
+			assert.Equal(t, c.want, filter(ev))
+		})
+	}
+	assert.Nil(t, newEventFilter("", "", nil))
+}
@@ -0,0 +1,244 @@
+package streaming
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"goa.design/pulse/streaming/options"
+)
+
+// subscription is a subscription to the events of a sink or a reader.
+type subscription struct {
+	// c is the subscription channel.
+	c chan *Event
+	// overflow is the policy applied when c is full.
+	overflow options.OverflowPolicy
+	// dropped is the number of events dropped because c was full.
+	dropped atomic.Int64
+	// lock serializes sends and protects closed.
+	lock sync.Mutex
+	// closed is true once the subscription failed or was removed. c is
+	// closed once no blocking send is in progress.
+	closed bool
+	// done is closed when the subscription is cancelled or closed so that
+	// blocking sends return.
+	done chan struct{}
+	// stop closes done once.
+	stop sync.Once
+	// sending tracks the blocking sends made without holding lock.
+	sending sync.WaitGroup
+	// track is true if the subscription records the sequence numbers of
+	// the events sent to c, see unconsumed.
+	track bool
+	// first is the sequence number of the first event that may be sent to
+	// c.
+	first uint64
+	// histLock protects history, it is never held while sending to c.
+	histLock sync.Mutex
+	// history is the sequence numbers of the last events sent to c, oldest
+	// first.
+	history []uint64
+}
+
+// newSubscription creates a new subscription whose channel has the given
+// buffer size unless overridden by opts. OverflowDropOldest behaves like
+// OverflowDropNewest for unbuffered channels as there is no buffered event
+// to drop.
+func newSubscription(bufferSize int, opts ...options.Subscribe) *subscription {
+	o := options.ParseSubscribeOptions(opts...)
+	if o.BufferSize > 0 {
+		bufferSize = o.BufferSize
+	}
+	overflow := o.Overflow
+	if overflow == options.OverflowDropOldest && bufferSize <= 0 {
+		overflow = options.OverflowDropNewest
+	}
+	return &subscription{
+		c:        make(chan *Event, bufferSize),
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+}
+
+// send delivers ev to the subscription, applying the overflow policy if the
+// channel is full. It returns false if the subscription failed as a result.
+func (sub *subscription) send(ev *Event) bool {
+	sub.lock.Lock()
+	if sub.closed || sub.cancelled() {
+		sub.lock.Unlock()
+		return true
+	}
+	if sub.overflow == options.OverflowBlock {
+		// Block without holding the lock so that the subscription can be
+		// closed while the subscriber is stalled.
+		sub.sending.Add(1)
+		sub.lock.Unlock()
+		defer sub.sending.Done()
+		select {
+		case sub.c <- ev:
+			sub.record(ev)
+		case <-sub.done:
+		}
+		return true
+	}
+	defer sub.lock.Unlock()
+	switch sub.overflow {
+	case options.OverflowDropNewest:
+		select {
+		case sub.c <- ev:
+		default:
+			sub.dropped.Add(1)
+			return true
+		}
+	case options.OverflowDropOldest:
+		for {
+			select {
+			case sub.c <- ev:
+				sub.record(ev)
+				return true
+			default:
+			}
+			select {
+			case <-sub.c:
+				sub.dropped.Add(1)
+			default:
+			}
+		}
+	case options.OverflowFail:
+		select {
+		case sub.c <- ev:
+		default:
+			sub.dropped.Add(1)
+			sub.closed = true
+			sub.cancel()
+			close(sub.c)
+			return false
+		}
+	}
+	sub.record(ev)
+	return true
+}
+
+// record records the sequence number of ev once sent to c. It only keeps the
+// events that may still be in the channel buffer plus the last event received
+// by the subscriber which may still be processing it.
+func (sub *subscription) record(ev *Event) {
+	if !sub.track {
+		return
+	}
+	sub.histLock.Lock()
+	defer sub.histLock.Unlock()
+	sub.history = append(sub.history, ev.seq)
+	if n := len(sub.history) - cap(sub.c) - 1; n > 0 {
+		sub.history = sub.history[n:]
+	}
+}
+
+// unconsumed returns the sequence number of the oldest event sent to the
+// subscription that may not have been consumed yet. An event is consumed once
+// the subscriber has received the next event.
+func (sub *subscription) unconsumed() uint64 {
+	sub.histLock.Lock()
+	defer sub.histLock.Unlock()
+	if len(sub.history) == 0 {
+		return sub.first
+	}
+	i := len(sub.history) - len(sub.c) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sub.history[i]
+}
+
+// close closes the subscription channel if not already closed. It waits for
+// blocking sends in progress to return first.
+func (sub *subscription) close() {
+	sub.lock.Lock()
+	if sub.closed {
+		sub.lock.Unlock()
+		return
+	}
+	sub.closed = true
+	sub.cancel()
+	sub.lock.Unlock()
+	sub.sending.Wait()
+	close(sub.c)
+}
+
+// cancel stops the delivery of events to the subscription without closing
+// its channel, it interrupts any blocking send in progress.
+func (sub *subscription) cancel() {
+	sub.stop.Do(func() { close(sub.done) })
+}
+
+// cancelled returns true if the subscription was cancelled.
+func (sub *subscription) cancelled() bool {
+	select {
+	case <-sub.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscriptionIndex indexes subscriptions by channel. It does not rely on the
+// sink or reader lock, which the read loop holds while sending, so that
+// Unsubscribe and Close can interrupt a send blocked on a stalled subscriber
+// before acquiring that lock.
+type subscriptionIndex struct {
+	lock sync.Mutex
+	subs map[<-chan *Event]*subscription
+}
+
+// add indexes sub.
+func (x *subscriptionIndex) add(sub *subscription) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.subs == nil {
+		x.subs = make(map[<-chan *Event]*subscription)
+	}
+	x.subs[sub.c] = sub
+}
+
+// cancel removes the subscription with channel c from the index and cancels
+// it.
+func (x *subscriptionIndex) cancel(c <-chan *Event) {
+	x.lock.Lock()
+	sub, ok := x.subs[c]
+	delete(x.subs, c)
+	x.lock.Unlock()
+	if ok {
+		sub.cancel()
+	}
+}
+
+// cancelAll removes all the subscriptions from the index and cancels them.
+func (x *subscriptionIndex) cancelAll() {
+	x.lock.Lock()
+	subs := x.subs
+	x.subs = nil
+	x.lock.Unlock()
+	for _, sub := range subs {
+		sub.cancel()
+	}
+}
+
+// findSubscription returns the index of the subscription with channel c, -1
+// if none.
+func findSubscription(subs []*subscription, c <-chan *Event) int {
+	for i, sub := range subs {
+		if sub.c == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// dropped returns the number of events dropped by the subscription with
+// channel c, 0 if there is no such subscription.
+func dropped(subs []*subscription, c <-chan *Event) int64 {
+	if i := findSubscription(subs, c); i >= 0 {
+		return subs[i].dropped.Load()
+	}
+	return 0
+}
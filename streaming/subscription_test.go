@@ -0,0 +1,137 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+func TestSubscriptionOverflow(t *testing.T) {
+	events := []*Event{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	cases := []struct {
+		name        string
+		policy      options.OverflowPolicy
+		wantIDs     []string
+		wantDropped int64
+		wantFailed  bool
+	}{
+		{"drop newest", options.OverflowDropNewest, []string{"1", "2"}, 1, false},
+		{"drop oldest", options.OverflowDropOldest, []string{"2", "3"}, 1, false},
+		{"fail", options.OverflowFail, []string{"1", "2"}, 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sub := newSubscription(2, options.WithSubscribeOverflow(c.policy))
+			failed := false
+			for _, ev := range events {
+				if !sub.send(ev) {
+					failed = true
+				}
+			}
+			assert.Equal(t, c.wantFailed, failed)
+			assert.Equal(t, c.wantDropped, sub.dropped.Load())
+			var ids []string
+			for i := 0; i < len(c.wantIDs); i++ {
+				ids = append(ids, (<-sub.c).ID)
+			}
+			assert.Equal(t, c.wantIDs, ids)
+			if c.wantFailed {
+				_, ok := <-sub.c
+				assert.False(t, ok)
+			}
+			sub.close()
+		})
+	}
+}
+
+func TestSubscriptionUnbufferedDropOldest(t *testing.T) {
+	sub := newSubscription(0, options.WithSubscribeOverflow(options.OverflowDropOldest))
+	defer sub.close()
+	assert.True(t, sub.send(&Event{ID: "1"}))
+	assert.Equal(t, int64(1), sub.dropped.Load())
+}
+
+func TestSubscriptionBlockedSend(t *testing.T) {
+	for _, name := range []string{"close", "cancel"} {
+		t.Run(name, func(t *testing.T) {
+			sub := newSubscription(0)
+			var index subscriptionIndex
+			index.add(sub)
+			sent := make(chan bool)
+			go func() { sent <- sub.send(&Event{ID: "1"}) }()
+			select {
+			case <-sent:
+				t.Fatal("send did not block")
+			case <-time.After(delay):
+			}
+			if name == "close" {
+				sub.close()
+			} else {
+				index.cancel(sub.c)
+			}
+			select {
+			case ok := <-sent:
+				assert.True(t, ok)
+			case <-time.After(max):
+				t.Fatal("send still blocked")
+			}
+			sub.close()
+			_, ok := <-sub.c
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestSubscriptionUnconsumed(t *testing.T) {
+	sub := newSubscription(2)
+	sub.track = true
+	sub.first = 1
+	assert.Equal(t, uint64(1), sub.unconsumed(), "nothing sent")
+	for seq := uint64(1); seq <= 2; seq++ {
+		sub.send(&Event{seq: seq})
+	}
+	assert.Equal(t, uint64(1), sub.unconsumed(), "nothing received")
+	<-sub.c
+	assert.Equal(t, uint64(1), sub.unconsumed(), "first event may be processing")
+	<-sub.c
+	assert.Equal(t, uint64(2), sub.unconsumed(), "first event consumed")
+	sub.send(&Event{seq: 3})
+	sub.send(&Event{seq: 4})
+	assert.Equal(t, uint64(2), sub.unconsumed(), "second event may be processing")
+	<-sub.c
+	assert.Equal(t, uint64(3), sub.unconsumed())
+	assert.Len(t, sub.history, 3, "history only keeps the buffered and last received events")
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewStream(testName, rdb, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	reader, err := s.NewReader(ctx, options.WithReaderStartAtOldest(), options.WithReaderBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupReader(t, ctx, s, reader)
+
+	// slow never reads its channel
+	slow := reader.Subscribe(options.WithSubscribeBufferSize(1), options.WithSubscribeOverflow(options.OverflowDropNewest))
+	c := reader.Subscribe()
+	for i := 0; i < 3; i++ {
+		_, err := s.Add(ctx, "event", []byte("payload"))
+		require.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		readOneReaderEvent(t, c)
+	}
+	assert.Eventually(t, func() bool { return reader.Dropped(slow) == 2 }, max, delay)
+	assert.Len(t, slow, 1)
+	assert.Equal(t, int64(0), reader.Dropped(c))
+}
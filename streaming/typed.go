@@ -0,0 +1,354 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+)
+
+type (
+	// Codec encodes and decodes the payloads of typed stream events.
+	Codec[T any] interface {
+		// Encode encodes v into an event payload.
+		Encode(v T) ([]byte, error)
+		// Decode decodes the given event payload.
+		Decode(data []byte) (T, error)
+	}
+
+	// TypedStream is a stream whose event payloads are values of type T
+	// encoded with a codec. Each event records the schema version it was
+	// written with.
+	TypedStream[T any] struct {
+		*Stream
+		// SchemaVersion is the version of the schema recorded with each
+		// event added to the stream.
+		SchemaVersion int
+		// codec is the codec used to encode and decode payloads.
+		codec Codec[T]
+	}
+
+	// TypedEvent is an event read from a typed stream.
+	TypedEvent[T any] struct {
+		*Event
+		// Value is the decoded event payload.
+		Value T
+	}
+
+	// TypedHandler is the function called by TypedSink.Consume for each
+	// event, see Handler.
+	TypedHandler[T any] func(context.Context, *TypedEvent[T]) error
+
+	// DecodeErrorHandler is called with the events read from a typed stream
+	// whose payload cannot be decoded.
+	DecodeErrorHandler func(ev *Event, err error)
+
+	// TypedSink is a sink that decodes the events of a typed stream.
+	TypedSink[T any] struct {
+		*Sink
+		// subs are the typed subscriptions.
+		subs *typedSubscriptions[T]
+	}
+
+	// TypedReader is a reader that decodes the events of a typed stream.
+	TypedReader[T any] struct {
+		*Reader
+		// subs are the typed subscriptions.
+		subs *typedSubscriptions[T]
+	}
+
+	// typedSubscriptions decodes the events read from a sink or a reader and
+	// forwards them to the typed subscribers.
+	typedSubscriptions[T any] struct {
+		codec   Codec[T]
+		version int
+		onError DecodeErrorHandler
+		// ack acknowledges the events that cannot be decoded, nil for
+		// readers.
+		ack    func(*Event)
+		lock   sync.Mutex
+		chans  map[<-chan *TypedEvent[T]]*typedSubscription
+		logger pulse.Logger
+	}
+
+	// typedSubscription is a typed subscription.
+	typedSubscription struct {
+		// raw is the underlying sink or reader channel.
+		raw <-chan *Event
+		// done is closed when the subscription is removed.
+		done chan struct{}
+	}
+
+	// jsonCodec is the JSON codec.
+	jsonCodec[T any] struct{}
+
+	// gobCodec is the gob codec.
+	gobCodec[T any] struct{}
+)
+
+// NewTypedStream returns the typed stream with the given name, see NewStream.
+// Events added to the stream are encoded with codec and record the given
+// schema version which must be positive. Typed sinks and readers of the stream
+// report events written with a greater schema version as undecodable.
+func NewTypedStream[T any](name string, rdb redis.UniversalClient, codec Codec[T], version int, opts ...options.Stream) (*TypedStream[T], error) {
+	if codec == nil {
+		return nil, fmt.Errorf("pulse stream: codec is required")
+	}
+	if version <= 0 {
+		return nil, fmt.Errorf("pulse stream: schema version must be positive, got %d", version)
+	}
+	s, err := NewStream(name, rdb, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedStream[T]{Stream: s, SchemaVersion: version, codec: codec}, nil
+}
+
+// JSONCodec returns a codec that encodes values using JSON.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+// GobCodec returns a codec that encodes values using gob.
+func GobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+// Add encodes v and appends the resulting event to the stream, see
+// Stream.Add.
+func (ts *TypedStream[T]) Add(ctx context.Context, name string, v T, opts ...options.AddEvent) (string, error) {
+	payload, err := ts.codec.Encode(v)
+	if err != nil {
+		err = fmt.Errorf("failed to encode event: %w", err)
+		ts.logger.Error(err, "event", name)
+		return "", err
+	}
+	opts = append(opts, options.WithSchemaVersion(ts.SchemaVersion))
+	return ts.Stream.Add(ctx, name, payload, opts...)
+}
+
+// NewSink creates a new typed sink with the given name, see Stream.NewSink.
+// onError is called with the events that cannot be decoded, such events are
+// then acknowledged. Undecodable events are logged if onError is nil.
+func (ts *TypedStream[T]) NewSink(ctx context.Context, name string, onError DecodeErrorHandler, opts ...options.Sink) (*TypedSink[T], error) {
+	sink, err := ts.Stream.NewSink(ctx, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ack := func(ev *Event) { sink.Ack(context.Background(), ev) } // nolint: errcheck
+	return &TypedSink[T]{Sink: sink, subs: ts.newSubscriptions(onError, ack, sink.logger)}, nil
+}
+
+// NewReader creates a new typed reader, see Stream.NewReader. onError is
+// called with the events that cannot be decoded. Undecodable events are logged
+// if onError is nil.
+func (ts *TypedStream[T]) NewReader(ctx context.Context, onError DecodeErrorHandler, opts ...options.Reader) (*TypedReader[T], error) {
+	reader, err := ts.Stream.NewReader(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedReader[T]{Reader: reader, subs: ts.newSubscriptions(onError, nil, reader.logger)}, nil
+}
+
+// Subscribe returns a channel that receives the decoded events from the sink,
+// see Sink.Subscribe.
+func (s *TypedSink[T]) Subscribe(opts ...options.Subscribe) <-chan *TypedEvent[T] {
+	return s.subs.subscribe(s.Sink.Subscribe(opts...))
+}
+
+// Unsubscribe removes the channel from the sink and closes it.
+func (s *TypedSink[T]) Unsubscribe(c <-chan *TypedEvent[T]) {
+	if raw := s.subs.unsubscribe(c); raw != nil {
+		s.Sink.Unsubscribe(raw)
+	}
+}
+
+// Dropped returns the number of events dropped by the subscription with
+// channel c, see Sink.Dropped.
+func (s *TypedSink[T]) Dropped(c <-chan *TypedEvent[T]) int64 {
+	if raw := s.subs.raw(c); raw != nil {
+		return s.Sink.Dropped(raw)
+	}
+	return 0
+}
+
+// Ack acknowledges the event.
+func (s *TypedSink[T]) Ack(ctx context.Context, e *TypedEvent[T]) error {
+	return s.Sink.Ack(ctx, e.Event)
+}
+
+// Consume calls handler with each decoded event read from the sink, see
+// Sink.Consume. Events that cannot be decoded are given to the sink decode
+// error handler and acknowledged without calling handler.
+func (s *TypedSink[T]) Consume(ctx context.Context, handler TypedHandler[T], opts ...options.Consume) error {
+	o := options.ParseConsumeOptions(opts...)
+	return s.Sink.Consume(ctx, func(ctx context.Context, ev *Event) error {
+		te, err := s.subs.decode(ev)
+		if err != nil {
+			s.subs.fail(ev, err)
+			if o.NoAutoAck {
+				s.subs.ack(ev)
+			}
+			return nil
+		}
+		return handler(ctx, te)
+	}, opts...)
+}
+
+// Subscribe returns a channel that receives the decoded events from the
+// stream, see Reader.Subscribe.
+func (r *TypedReader[T]) Subscribe(opts ...options.Subscribe) <-chan *TypedEvent[T] {
+	return r.subs.subscribe(r.Reader.Subscribe(opts...))
+}
+
+// Unsubscribe removes the channel from the reader subscribers and closes it.
+func (r *TypedReader[T]) Unsubscribe(c <-chan *TypedEvent[T]) {
+	if raw := r.subs.unsubscribe(c); raw != nil {
+		r.Reader.Unsubscribe(raw)
+	}
+}
+
+// Dropped returns the number of events dropped by the subscription with
+// channel c, see Reader.Dropped.
+func (r *TypedReader[T]) Dropped(c <-chan *TypedEvent[T]) int64 {
+	if raw := r.subs.raw(c); raw != nil {
+		return r.Reader.Dropped(raw)
+	}
+	return 0
+}
+
+// newSubscriptions creates the typed subscriptions of a sink or reader.
+func (ts *TypedStream[T]) newSubscriptions(onError DecodeErrorHandler, ack func(*Event), logger pulse.Logger) *typedSubscriptions[T] {
+	return &typedSubscriptions[T]{
+		codec:   ts.codec,
+		version: ts.SchemaVersion,
+		onError: onError,
+		ack:     ack,
+		chans:   make(map[<-chan *TypedEvent[T]]*typedSubscription),
+		logger:  logger,
+	}
+}
+
+// subscribe creates a typed subscription that decodes the events received on
+// raw.
+func (ts *typedSubscriptions[T]) subscribe(raw <-chan *Event) <-chan *TypedEvent[T] {
+	c := make(chan *TypedEvent[T], cap(raw))
+	sub := &typedSubscription{raw: raw, done: make(chan struct{})}
+	ts.lock.Lock()
+	ts.chans[c] = sub
+	ts.lock.Unlock()
+	pulse.Go(context.Background(), func() { ts.forward(sub, c) })
+	return c
+}
+
+// unsubscribe removes the typed subscription and returns the underlying
+// channel, nil if c is not a known subscription. The caller must then remove
+// the underlying subscription: the forwarding goroutine drains the underlying
+// channel until it is closed so that the sink or reader never blocks on it,
+// see forward.
+func (ts *typedSubscriptions[T]) unsubscribe(c <-chan *TypedEvent[T]) <-chan *Event {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	sub, ok := ts.chans[c]
+	if !ok {
+		return nil
+	}
+	delete(ts.chans, c)
+	close(sub.done)
+	return sub.raw
+}
+
+// raw returns the underlying channel of the typed subscription, nil if c is
+// not a known subscription.
+func (ts *typedSubscriptions[T]) raw(c <-chan *TypedEvent[T]) <-chan *Event {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if sub, ok := ts.chans[c]; ok {
+		return sub.raw
+	}
+	return nil
+}
+
+// forward decodes the events received on the subscription raw channel and
+// sends them to c until the raw channel is closed. Events received once the
+// subscription is removed are discarded: forward keeps draining the raw
+// channel so that the sink or reader, which may hold its lock while sending,
+// can process the removal of the underlying subscription.
+func (ts *typedSubscriptions[T]) forward(sub *typedSubscription, c chan *TypedEvent[T]) {
+	defer close(c)
+	for ev := range sub.raw {
+		select {
+		case <-sub.done:
+			continue
+		default:
+		}
+		te, err := ts.decode(ev)
+		if err != nil {
+			ts.fail(ev, err)
+			if ts.ack != nil {
+				ts.ack(ev)
+			}
+			continue
+		}
+		select {
+		case c <- te:
+		case <-sub.done:
+		}
+	}
+}
+
+// decode decodes the event payload.
+func (ts *typedSubscriptions[T]) decode(ev *Event) (*TypedEvent[T], error) {
+	if ev.SchemaVersion > ts.version {
+		return nil, fmt.Errorf("unsupported schema version %d, latest is %d", ev.SchemaVersion, ts.version)
+	}
+	v, err := ts.codec.Decode(ev.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedEvent[T]{Event: ev, Value: v}, nil
+}
+
+// fail reports an event that cannot be decoded.
+func (ts *typedSubscriptions[T]) fail(ev *Event, err error) {
+	if ts.onError != nil {
+		ts.onError(ev, err)
+		return
+	}
+	ts.logger.Error(fmt.Errorf("failed to decode event: %w", err), "event", ev.EventName, "id", ev.ID, "stream", ev.StreamName)
+}
+
+// Encode encodes v using JSON.
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode decodes the JSON data.
+func (jsonCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// Encode encodes v using gob.
+func (gobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the gob data.
+func (gobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
@@ -0,0 +1,130 @@
+package streaming
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"goa.design/pulse/pulse"
+	"goa.design/pulse/streaming/options"
+	ptesting "goa.design/pulse/testing"
+)
+
+type testOrder struct {
+	ID    string
+	Total int
+}
+
+func TestTypedStream(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewTypedStream(testName, rdb, JSONCodec[testOrder](), 2, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	var (
+		lock   sync.Mutex
+		failed []string
+	)
+	onError := func(ev *Event, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		failed = append(failed, ev.ID)
+	}
+	sink, err := s.NewSink(ctx, "sink", onError, options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s.Stream, sink.Sink)
+	c := sink.Subscribe()
+
+	// Undecodable event is reported
+	badID, err := s.Stream.Add(ctx, "order", []byte("not json"))
+	require.NoError(t, err)
+	// Newer schema version is reported
+	newerID, err := s.Stream.Add(ctx, "order", []byte(`{"ID":"1"}`), options.WithSchemaVersion(3))
+	require.NoError(t, err)
+	_, err = s.Add(ctx, "order", testOrder{ID: "2", Total: 42})
+	require.NoError(t, err)
+
+	select {
+	case ev := <-c:
+		assert.Equal(t, testOrder{ID: "2", Total: 42}, ev.Value)
+		assert.Equal(t, 2, ev.SchemaVersion)
+		assert.NoError(t, sink.Ack(ctx, ev))
+	case <-time.After(max):
+		t.Fatal("timeout waiting for event")
+	}
+	lock.Lock()
+	assert.Equal(t, []string{badID, newerID}, failed)
+	lock.Unlock()
+
+	sink.Unsubscribe(c)
+	_, ok := <-c
+	assert.False(t, ok)
+}
+
+func TestTypedUnsubscribeFullBuffer(t *testing.T) {
+	testName := strings.Replace(t.Name(), "/", "_", -1)
+	rdb := ptesting.NewRedisClient(t)
+	defer ptesting.CleanupRedis(t, rdb, false, "")
+	ctx := ptesting.NewTestContext(t)
+	s, err := NewTypedStream(testName, rdb, JSONCodec[testOrder](), 1, options.WithStreamLogger(pulse.ClueLogger(ctx)))
+	require.NoError(t, err)
+	sink, err := s.NewSink(ctx, "sink", nil, options.WithSinkBlockDuration(testBlockDuration))
+	require.NoError(t, err)
+	defer cleanupSink(t, ctx, s.Stream, sink.Sink)
+	c := sink.Subscribe(options.WithSubscribeBufferSize(1))
+
+	// Fill both the typed and the underlying buffers so that the sink
+	// blocks sending to the underlying channel.
+	for i := 0; i < 5; i++ {
+		_, err = s.Add(ctx, "order", testOrder{ID: "1"})
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool { return len(c) == 1 }, max, delay)
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		sink.Unsubscribe(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(max):
+		t.Fatal("timeout waiting for unsubscribe")
+	}
+	assert.Eventually(t, func() bool {
+		select {
+		case _, ok := <-c:
+			return !ok
+		default:
+			return false
+		}
+	}, max, delay)
+}
+
+func TestCodecs(t *testing.T) {
+	v := testOrder{ID: "1", Total: 42}
+	for name, codec := range map[string]Codec[testOrder]{"json": JSONCodec[testOrder](), "gob": GobCodec[testOrder]()} {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Encode(v)
+			require.NoError(t, err)
+			got, err := codec.Decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, v, got)
+			_, err = codec.Decode([]byte("\x00invalid"))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewTypedStreamErrors(t *testing.T) {
+	_, err := NewTypedStream[testOrder]("test", nil, nil, 1)
+	assert.Error(t, err)
+	_, err = NewTypedStream("test", nil, JSONCodec[testOrder](), 0)
+	assert.Error(t, err)
+}
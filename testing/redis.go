@@ -41,10 +41,14 @@ func CleanupRedis(t *testing.T, rdb *redis.Client, checkClean bool, testName str
 		require.NoError(t, err)
 		var filtered []string
 		for _, k := range keys {
-			if strings.HasSuffix(k, ":sinks:content") {
+			if strings.HasSuffix(k, ":sinks:content") || strings.HasSuffix(k, ":sinks}:content") {
 				// Sinks content is cleaned up asynchronously, so ignore it
 				continue
 			}
+			if strings.HasPrefix(k, "map:") && (strings.HasSuffix(k, ":rev") || strings.HasSuffix(k, ":seq")) {
+				// Replicated map counters are preserved across resets, so ignore them
+				continue
+			}
 			if regexp.MustCompile(`^pulse:stream:[^:]+:node:.*`).MatchString(k) {
 				// Node streams are cleaned up asynchronously, so ignore them
 				continue